@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// walkWorkspace 遍历目录并跳过被排除的路径，无法读取的子项会被忽略。
+func walkWorkspace(root string, matcher *ignoreMatcher, fn func(abs, rel string, entry fs.DirEntry) error) error {
+	matcher.LoadParents(root)
+	return filepath.WalkDir(root, func(abs string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if abs == root {
+				return err
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, abs)
+		if err != nil {
+			return err
+		}
+		if rel != "." && matcher.Ignored(rel, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			matcher.LoadDir(abs, rel)
+		}
+		return fn(abs, rel, entry)
+	})
+}
+
+// queryBool 读取布尔查询参数，缺省或无法解析时返回默认值。
+func queryBool(r *http.Request, name string, fallback bool) bool {
+	value, err := strconv.ParseBool(r.URL.Query().Get(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// requestIgnoreMatcher 按请求参数创建匹配器：gitignore 参数控制是否遵循 .gitignore（缺省取 gitignore），excludes=false 时不使用默认排除列表。
+func requestIgnoreMatcher(r *http.Request, manager *SessionManager, gitignore bool) *ignoreMatcher {
+	excludes := []string{trashDirName}
+	if queryBool(r, "excludes", true) {
+		excludes = append(excludes, manager.excludes...)
+	}
+	return newIgnoreMatcher(excludes, queryBool(r, "gitignore", gitignore))
+}
+
+// archiveFormat 解析打包格式参数，默认 zip。
+func archiveFormat(r *http.Request) (string, bool) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "zip":
+		return "zip", true
+	case "tar.gz", "tgz":
+		return "tar.gz", true
+	default:
+		return format, false
+	}
+}
+
+// streamDirectoryArchive 以 zip 或 tar.gz 流式输出目录，不在内存中缓存整个压缩包。
+func streamDirectoryArchive(w http.ResponseWriter, dir, format string, matcher *ignoreMatcher) error {
+	name := filepath.Base(dir)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", name, format))
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		return writeZipArchive(w, dir, name, matcher)
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	return writeTarGzArchive(w, dir, name, matcher)
+}
+
+// writeZipArchive 将目录写入 zip 流，条目以目录名为前缀。
+func writeZipArchive(w io.Writer, dir, prefix string, matcher *ignoreMatcher) error {
+	archive := zip.NewWriter(w)
+	err := walkWorkspace(dir, matcher, func(abs, rel string, entry fs.DirEntry) error {
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, filepath.ToSlash(rel))
+		switch {
+		case info.IsDir():
+			header.Name += "/"
+			_, err = archive.CreateHeader(header)
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			// 重要逻辑：符号链接只记录链接目标，不读取其指向的内容。
+			link, err := os.Readlink(abs)
+			if err != nil {
+				return nil
+			}
+			writer, err := archive.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(writer, link)
+			return err
+		case info.Mode().IsRegular():
+			header.Method = zip.Deflate
+			writer, err := archive.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			return copyFileTo(writer, abs, -1)
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// writeTarGzArchive 将目录写入 tar.gz 流，条目以目录名为前缀。
+func writeTarGzArchive(w io.Writer, dir, prefix string, matcher *ignoreMatcher) error {
+	compressor := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressor)
+	err := walkWorkspace(dir, matcher, func(abs, rel string, entry fs.DirEntry) error {
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(abs); err != nil {
+				return nil
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			return copyFileTo(archive, abs, header.Size)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+// copyFileTo 将文件内容写入 writer，size 不小于 0 时只写入指定长度。
+func copyFileTo(writer io.Writer, path string, size int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if size < 0 {
+		_, err = io.Copy(writer, file)
+		return err
+	}
+	_, err = io.CopyN(writer, file, size)
+	return err
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 200
+	maxAuditLimit     = 2000
+)
+
+// AuditEvent 是审计日志中的一条记录。
+type AuditEvent struct {
+	Time      time.Time      `json:"time"`
+	Action    string         `json:"action"`
+	User      string         `json:"user,omitempty"`
+	IP        string         `json:"ip,omitempty"`
+	SessionID string         `json:"session_id,omitempty"`
+	Path      string         `json:"path,omitempty"`
+	Success   bool           `json:"success"`
+	Detail    map[string]any `json:"detail,omitempty"`
+}
+
+// AuditLogger 以 JSON Lines 追加写入审计日志。
+type AuditLogger struct {
+	mu           sync.Mutex
+	path         string
+	file         *os.File
+	captureInput bool
+}
+
+type auditLoggerKey struct{}
+
+// NewAuditLogger 打开审计日志文件，path 为空时返回不记录的 AuditLogger。
+// captureInput 为 true 时同时记录终端输入。
+func NewAuditLogger(path string, captureInput bool) (*AuditLogger, error) {
+	logger := &AuditLogger{path: path, captureInput: captureInput}
+	if path == "" {
+		return logger, nil
+	}
+	// 重要逻辑：以 O_APPEND 打开，只追加不覆盖，权限仅限当前用户。
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	logger.file = file
+	return logger, nil
+}
+
+// Record 写入一条审计记录。
+func (a *AuditLogger) Record(event AuditEvent) {
+	if a == nil || a.file == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	a.mu.Lock()
+	_, _ = a.file.Write(data)
+	a.mu.Unlock()
+}
+
+// CaptureInput 判断是否需要记录终端输入。
+func (a *AuditLogger) CaptureInput() bool {
+	return a != nil && a.file != nil && a.captureInput
+}
+
+// Query 按时间范围与动作前缀查询审计记录，返回最近的 limit 条（按时间正序）。
+func (a *AuditLogger) Query(since, until time.Time, action, user string, limit int) ([]AuditEvent, error) {
+	if a == nil || a.path == "" {
+		return []AuditEvent{}, nil
+	}
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// 重要逻辑：使用环形缓冲只保留最后 limit 条匹配记录，避免日志过大时占用内存。
+	buffer := make([]AuditEvent, limit)
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if !since.IsZero() && event.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && event.Time.After(until) {
+			continue
+		}
+		if action != "" && !strings.HasPrefix(event.Action, action) {
+			continue
+		}
+		if user != "" && event.User != user {
+			continue
+		}
+		buffer[count%limit] = event
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if count <= limit {
+		return buffer[:count], nil
+	}
+	start := count % limit
+	result := make([]AuditEvent, 0, limit)
+	result = append(result, buffer[start:]...)
+	result = append(result, buffer[:start]...)
+	return result, nil
+}
+
+// withAudit 将审计记录器放入请求上下文，供各处理函数记录操作。
+func withAudit(audit *AuditLogger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), auditLoggerKey{}, audit)))
+	})
+}
+
+// recordAudit 补全请求的用户与 IP 后写入审计记录。
+func recordAudit(r *http.Request, event AuditEvent) {
+	audit, ok := r.Context().Value(auditLoggerKey{}).(*AuditLogger)
+	if !ok {
+		return
+	}
+	if event.User == "" {
+		if principal := requestPrincipal(r); principal != nil {
+			event.User = principal.Username
+		}
+	}
+	if event.IP == "" {
+		event.IP = requestClientIP(r)
+	}
+	audit.Record(event)
+}
+
+// HandleAuditQuery 查询审计日志（仅管理员）。
+func HandleAuditQuery(audit *AuditLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		principal := requestPrincipal(r)
+		if principal != nil && !principal.IsAdmin() {
+			writeError(w, http.StatusForbidden, "admin required")
+			return
+		}
+		query := r.URL.Query()
+		since, err := parseAuditTime(query.Get("since"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since")
+			return
+		}
+		until, err := parseAuditTime(query.Get("until"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid until")
+			return
+		}
+		limit := defaultAuditLimit
+		if raw := query.Get("limit"); raw != "" {
+			if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+				limit = parsed
+			}
+		}
+		if limit > maxAuditLimit {
+			limit = maxAuditLimit
+		}
+		events, err := audit.Query(since, until, query.Get("action"), query.Get("user"), limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "read audit log failed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"events": events})
+	}
+}
+
+// parseAuditTime 解析 RFC3339 或 Unix 秒时间戳，空值返回零值。
+func parseAuditTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
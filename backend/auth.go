@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const authCookieName = "anywhere_token"
+
+// Authenticator 负责登录校验与令牌签发。
+type Authenticator struct {
+	password string
+	token    string
+	secret   []byte
+	ttl      time.Duration
+	users    *UserStore
+}
+
+// TokenClaims 是签名令牌中携带的信息。
+type TokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// LoginRequest 是登录请求。
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	OTP      string `json:"otp"`
+}
+
+type principalKey struct{}
+
+// NewAuthenticator 根据配置创建 Authenticator。
+func NewAuthenticator(cfg Config, users *UserStore) *Authenticator {
+	secret := []byte(cfg.AuthSecret)
+	if len(secret) == 0 {
+		// 重要逻辑：未配置密钥时随机生成，重启后已签发的令牌全部失效。
+		secret = make([]byte, 32)
+		_, _ = rand.Read(secret)
+	}
+	return &Authenticator{
+		password: cfg.AuthPassword,
+		token:    cfg.AuthToken,
+		secret:   secret,
+		ttl:      cfg.AuthTTL,
+		users:    users,
+	}
+}
+
+// Enabled 判断是否启用了认证。
+func (a *Authenticator) Enabled() bool {
+	return a.password != "" || a.token != "" || !a.users.Empty()
+}
+
+// IssueToken 为指定主体签发令牌。
+func (a *Authenticator) IssueToken(subject string) (string, time.Time) {
+	now := time.Now()
+	expiresAt := now.Add(a.ttl)
+	claims := TokenClaims{Subject: subject, IssuedAt: now.Unix(), ExpiresAt: expiresAt.Unix()}
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + a.sign(encoded), expiresAt
+}
+
+// VerifyToken 校验令牌签名与有效期。
+func (a *Authenticator) VerifyToken(token string) (*TokenClaims, error) {
+	// 重要逻辑：静态令牌用于脚本调用，直接视为管理员。
+	if a.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+		return &TokenClaims{Subject: roleAdmin}, nil
+	}
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid token")
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(a.sign(parts[0]))) {
+		return nil, errors.New("invalid token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("invalid token")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.New("token expired")
+	}
+	return &claims, nil
+}
+
+// CheckPassword 校验登录凭据，返回登录用户名。
+func (a *Authenticator) CheckPassword(username, password string) (string, bool) {
+	if user, ok := a.users.Authenticate(username, password); ok {
+		return user.Username, true
+	}
+	// 重要逻辑：APP_AUTH_PASSWORD 作为内置管理员密码，用于初始化用户账号。
+	if a.password == "" || (username != "" && username != roleAdmin) {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) != 1 {
+		return "", false
+	}
+	return roleAdmin, true
+}
+
+// ResolvePrincipal 将令牌主体映射为当前身份，用户被删除后令牌立即失效。
+func (a *Authenticator) ResolvePrincipal(claims *TokenClaims) (*Principal, error) {
+	if user, ok := a.users.Get(claims.Subject); ok {
+		return &Principal{Username: user.Username, Role: user.Role, RunAs: user.RunAs}, nil
+	}
+	if claims.Subject == roleAdmin && (a.password != "" || a.token != "") {
+		return &Principal{Username: roleAdmin, Role: roleAdmin}, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+// sign 计算令牌载荷的 HMAC 签名。
+func (a *Authenticator) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HandleLogin 校验密码并签发令牌（同时写入 Cookie）。
+func HandleLogin(auth *Authenticator, limiter *RateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !auth.Enabled() {
+			writeError(w, http.StatusNotFound, "auth disabled")
+			return
+		}
+		// 重要逻辑：按客户端 IP 统计登录尝试，校验前先计数、成功后清零，锁定期内直接拒绝，防止暴力破解。
+		clientIP := requestClientIP(r)
+		if retryAfter, ok := limiter.Reserve(clientIP); !ok {
+			writeRateLimited(w, retryAfter)
+			return
+		}
+
+		var payload LoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		username, ok := auth.CheckPassword(payload.Username, payload.Password)
+		if !ok {
+			recordAudit(r, AuditEvent{Action: "auth.login", User: payload.Username, Success: false, Detail: map[string]any{"reason": "invalid credentials"}})
+			writeError(w, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+		// 重要逻辑：开启二次验证的用户必须同时提交动态验证码或恢复码。
+		if auth.users.TOTPEnabled(username) {
+			if payload.OTP == "" {
+				writeJSON(w, http.StatusUnauthorized, map[string]any{
+					"ok":            false,
+					"message":       "otp required",
+					"totp_required": true,
+				})
+				return
+			}
+			if !auth.users.VerifySecondFactor(username, payload.OTP) {
+				recordAudit(r, AuditEvent{Action: "auth.login", User: username, Success: false, Detail: map[string]any{"reason": "invalid otp"}})
+				writeError(w, http.StatusUnauthorized, "invalid otp")
+				return
+			}
+		}
+		limiter.Reset(clientIP)
+
+		token, expiresAt := auth.IssueToken(username)
+		csrfToken, err := issueCSRFCookie(w, r, expiresAt)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "generate csrf token failed")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     authCookieName,
+			Value:    token,
+			Path:     "/",
+			Expires:  expiresAt,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		recordAudit(r, AuditEvent{Action: "auth.login", User: username, Success: true})
+		writeJSON(w, http.StatusOK, map[string]any{
+			"ok":         true,
+			"token":      token,
+			"user":       username,
+			"csrf_token": csrfToken,
+			"expires_at": expiresAt,
+		})
+	}
+}
+
+// HandleLogout 清除登录 Cookie。
+func HandleLogout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     authCookieName,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		recordAudit(r, AuditEvent{Action: "auth.logout", Success: true})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
+
+// HandleAuthStatus 返回认证是否启用以及当前请求是否已登录。
+func HandleAuthStatus(auth *Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		result := map[string]any{"enabled": auth.Enabled(), "authenticated": !auth.Enabled()}
+		if auth.Enabled() {
+			if claims, err := auth.VerifyToken(requestToken(r)); err == nil {
+				if principal, err := auth.ResolvePrincipal(claims); err == nil {
+					result["authenticated"] = true
+					result["user"] = principal.Username
+					result["role"] = principal.Role
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// withAuth 校验 API 请求的登录状态。
+func withAuth(auth *Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.Enabled() || isPublicPath(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// 重要逻辑：WebSocket 握手无法自定义请求头，令牌可通过 Cookie 或 ?token= 传入。
+		claims, err := auth.VerifyToken(requestToken(r))
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		principal, err := auth.ResolvePrincipal(claims)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// isPublicPath 判断请求是否无需登录。
+func isPublicPath(r *http.Request) bool {
+	if r.Method == http.MethodOptions {
+		return true
+	}
+	switch r.URL.Path {
+	case "/api/health", "/api/auth/login", "/api/auth/logout", "/api/auth/status", "/api/auth/csrf":
+		return true
+	case "/api/ws":
+		// 重要逻辑：携带分享令牌的连接由 WebSocketHandler 自行校验。
+		return r.URL.Query().Get("share") != ""
+	}
+	// 重要逻辑：静态前端资源需要在登录前加载，只保护 /api 接口。
+	return !strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// requestToken 依次从 Authorization 头、Cookie 与查询参数读取令牌。
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if cookie, err := r.Cookie(authCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	return r.URL.Query().Get("token")
+}
+
+// requestPrincipal 返回请求的身份，未启用认证时返回 nil。
+func requestPrincipal(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalKey{}).(*Principal)
+	return principal
+}
@@ -3,14 +3,49 @@ package main
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config 保存服务运行所需的配置项。
 type Config struct {
-	Port     string
-	Shell    string
-	StaticDir string
-	BufferSize int
+	Port           string
+	Shell          string
+	StaticDir      string
+	BufferSize     int
+	Limits         ResourceLimits
+	CgroupRoot     string
+	RunAs          string
+	RunAsAllowed   []string
+	AuthPassword   string
+	AuthToken      string
+	AuthSecret     string
+	AuthTTL        time.Duration
+	UsersFile      string
+	AllowedOrigins []string
+	BindAddr       string
+	TLSEnabled     bool
+	TLSCert        string
+	TLSKey         string
+	TLSDir         string
+	HTTPRedirect   string
+	AllowedCIDRs   []string
+	TrustedProxies []string
+	LoginMaxFails  int
+	LoginWindow    time.Duration
+	SessionRate    int
+	LockoutPeriod  time.Duration
+	AuditLog       string
+	AuditInput     bool
+	Redact         bool
+	RedactFile     string
+	FollowSymlinks bool
+	WorkspaceRoots []string
+	TrashRetention time.Duration
+	FSExcludes     []string
+	UploadDir      string
+	UploadTTL      time.Duration
+	UploadMaxSize  int64
 }
 
 // LoadConfig 从环境变量加载配置。
@@ -19,12 +54,104 @@ func LoadConfig() Config {
 	shell := getenvDefault("APP_SHELL", "/bin/bash")
 	staticDir := os.Getenv("APP_STATIC_DIR")
 	bufferSize := getenvDefaultInt("APP_BUFFER_SIZE", 2*1024*1024)
+	// 重要逻辑：资源限制为所有会话的默认值与上限，创建会话时只能进一步收紧。
+	limits := ResourceLimits{
+		MaxMemory:      int64(getenvDefaultInt("APP_LIMIT_MEMORY_MB", 0)) * 1024 * 1024,
+		CPUPercent:     getenvDefaultInt("APP_LIMIT_CPU_PERCENT", 0),
+		MaxProcesses:   getenvDefaultInt("APP_LIMIT_PROCESSES", 0),
+		MaxOpenFiles:   getenvDefaultInt("APP_LIMIT_OPEN_FILES", 0),
+		TimeoutSeconds: getenvDefaultInt("APP_LIMIT_TIMEOUT_SECONDS", 0),
+	}
+	cgroupRoot := getenvDefault("APP_CGROUP_ROOT", "/sys/fs/cgroup/anywhere-code")
+	// 重要逻辑：APP_RUN_AS 为默认账号（user[:group]），APP_RUN_AS_ALLOWED 为可按会话选择的账号。
+	runAs := os.Getenv("APP_RUN_AS")
+	runAsAllowed := getenvList("APP_RUN_AS_ALLOWED")
+	// 重要逻辑：配置了密码或静态令牌即启用认证。
+	authPassword := os.Getenv("APP_AUTH_PASSWORD")
+	authToken := os.Getenv("APP_AUTH_TOKEN")
+	authSecret := os.Getenv("APP_AUTH_SECRET")
+	authTTL := time.Duration(getenvDefaultInt("APP_AUTH_TTL_HOURS", 24*7)) * time.Hour
+	usersFile := os.Getenv("APP_USERS_FILE")
+	// 重要逻辑：未配置来源白名单时只允许与服务同主机名的页面访问。
+	allowedOrigins := getenvList("APP_ALLOWED_ORIGINS")
+	bindAddr := os.Getenv("APP_BIND_ADDR")
+	tlsCert := os.Getenv("APP_TLS_CERT")
+	tlsKey := os.Getenv("APP_TLS_KEY")
+	// 重要逻辑：配置了证书或 APP_TLS=true 时启用 HTTPS，无证书则自动生成自签名证书。
+	tlsEnabled := getenvDefaultBool("APP_TLS", tlsCert != "" || tlsKey != "")
+	tlsDir := getenvDefault("APP_TLS_DIR", "certs")
+	httpRedirect := os.Getenv("APP_HTTP_REDIRECT_PORT")
+	// 重要逻辑：APP_ALLOWED_CIDRS 为空时不限制来源网段，X-Forwarded-For 仅在来自可信代理时生效。
+	allowedCIDRs := getenvList("APP_ALLOWED_CIDRS")
+	trustedProxies := getenvList("APP_TRUSTED_PROXIES")
+	loginMaxFails := getenvDefaultInt("APP_LOGIN_MAX_FAILURES", 5)
+	loginWindow := time.Duration(getenvDefaultInt("APP_LOGIN_WINDOW_SECONDS", 300)) * time.Second
+	sessionRate := getenvDefaultInt("APP_SESSION_CREATE_PER_MINUTE", 20)
+	lockoutPeriod := time.Duration(getenvDefaultInt("APP_LOCKOUT_SECONDS", 900)) * time.Second
+	// 重要逻辑：审计日志默认开启，设为 off 时关闭。
+	auditLog := getenvDefault("APP_AUDIT_LOG", "audit.jsonl")
+	if auditLog == "off" {
+		auditLog = ""
+	}
+	// 重要逻辑：终端输入审计需显式开启，关闭回显时的输入（如密码）不会记录明文。
+	auditInput := getenvDefaultBool("APP_AUDIT_INPUT", false)
+	// 重要逻辑：回放、导出与搜索默认使用内置规则脱敏，APP_REDACT_FILE 可追加自定义正则。
+	redact := getenvDefaultBool("APP_REDACT", true)
+	redactFile := os.Getenv("APP_REDACT_FILE")
+	// 重要逻辑：文件接口默认不跟随指向根目录之外的符号链接。
+	followSymlinks := getenvDefaultBool("APP_FS_FOLLOW_SYMLINKS", false)
+	// 重要逻辑：APP_WORKSPACE_ROOTS 为 name=path 列表，配置后文件与 Git 接口只能访问这些目录。
+	workspaceRoots := getenvList("APP_WORKSPACE_ROOTS")
+	// 重要逻辑：通过接口删除的文件进入回收站，超过保留天数后自动清理（0 表示不清理）。
+	trashRetention := time.Duration(getenvDefaultInt("APP_TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour
+	// 重要逻辑：打包下载与搜索默认跳过依赖与缓存目录，APP_FS_EXCLUDES 可覆盖默认列表。
+	fsExcludes := getenvList("APP_FS_EXCLUDES")
+	if len(fsExcludes) == 0 {
+		fsExcludes = defaultExcludes
+	}
+	// 重要逻辑：分片上传的数据暂存在 APP_UPLOAD_DIR，超过有效期未完成的上传会被清理。
+	uploadDir := getenvDefault("APP_UPLOAD_DIR", "uploads")
+	uploadTTL := time.Duration(getenvDefaultInt("APP_UPLOAD_TTL_HOURS", 24)) * time.Hour
+	uploadMaxSize := int64(getenvDefaultInt("APP_UPLOAD_MAX_MB", 10240)) * 1024 * 1024
 
 	return Config{
-		Port:     port,
-		Shell:    shell,
-		StaticDir: staticDir,
-		BufferSize: bufferSize,
+		Port:           port,
+		Shell:          shell,
+		StaticDir:      staticDir,
+		BufferSize:     bufferSize,
+		Limits:         limits,
+		CgroupRoot:     cgroupRoot,
+		RunAs:          runAs,
+		RunAsAllowed:   runAsAllowed,
+		AuthPassword:   authPassword,
+		AuthToken:      authToken,
+		AuthSecret:     authSecret,
+		AuthTTL:        authTTL,
+		UsersFile:      usersFile,
+		AllowedOrigins: allowedOrigins,
+		BindAddr:       bindAddr,
+		TLSEnabled:     tlsEnabled,
+		TLSCert:        tlsCert,
+		TLSKey:         tlsKey,
+		TLSDir:         tlsDir,
+		HTTPRedirect:   httpRedirect,
+		AllowedCIDRs:   allowedCIDRs,
+		TrustedProxies: trustedProxies,
+		LoginMaxFails:  loginMaxFails,
+		LoginWindow:    loginWindow,
+		SessionRate:    sessionRate,
+		LockoutPeriod:  lockoutPeriod,
+		AuditLog:       auditLog,
+		AuditInput:     auditInput,
+		Redact:         redact,
+		RedactFile:     redactFile,
+		FollowSymlinks: followSymlinks,
+		WorkspaceRoots: workspaceRoots,
+		TrashRetention: trashRetention,
+		FSExcludes:     fsExcludes,
+		UploadDir:      uploadDir,
+		UploadTTL:      uploadTTL,
+		UploadMaxSize:  uploadMaxSize,
 	}
 }
 
@@ -38,6 +165,31 @@ func getenvDefault(key, fallback string) string {
 	return value
 }
 
+// getenvList 读取逗号分隔的环境变量列表，忽略空项。
+func getenvList(key string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getenvDefaultBool 读取布尔型环境变量，缺省或非法时返回默认值。
+func getenvDefaultBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
 // getenvDefaultInt 读取整型环境变量，缺省时返回默认值。
 func getenvDefaultInt(key string, fallback int) int {
 	value := os.Getenv(key)
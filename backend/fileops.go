@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// FilePathRequest 是只涉及单个路径的文件操作请求。
+type FilePathRequest struct {
+	Path      string `json:"path"`
+	Parents   bool   `json:"parents"`
+	Recursive bool   `json:"recursive"`
+	Confirm   bool   `json:"confirm"`
+	Permanent bool   `json:"permanent"`
+}
+
+// FileTransferRequest 是移动或复制文件的请求。
+type FileTransferRequest struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+var (
+	errRootEntry       = errors.New("cannot operate on root")
+	errConfirmRequired = errors.New("recursive delete requires confirm")
+	errCopyIntoSelf    = errors.New("cannot copy or move a directory into itself")
+	errSameEntry       = errors.New("source and target are the same")
+	errTargetIsParent  = errors.New("target contains the source")
+)
+
+// resolveSafeEntry 解析目录项本身的路径：只解析父目录中的符号链接，
+// 末尾的符号链接按链接本身处理，删除或移动链接不会影响其指向的文件。
+func resolveSafeEntry(root, rel string, followSymlinks bool) (string, error) {
+	clean := filepath.Clean(rel)
+	if clean == "." || clean == string(filepath.Separator) {
+		return "", errRootEntry
+	}
+	if clean == ".." {
+		return "", errPathEscape
+	}
+	parent, err := resolveSafePath(root, filepath.Dir(clean), followSymlinks)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(clean)), nil
+}
+
+// beginFileOp 校验文件操作请求的方法与会话，并解析根目录与请求体。
+func beginFileOp(w http.ResponseWriter, r *http.Request, manager *SessionManager, payload any) (string, string, bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return "", "", false
+	}
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		writeError(w, http.StatusBadRequest, "session_id required")
+		return "", "", false
+	}
+	root, _, err := resolveFileRoot(r, manager, sessionID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return "", "", false
+	}
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return "", "", false
+	}
+	return sessionID, root, true
+}
+
+// writeFileOpError 将文件操作错误转换为带错误码的响应。
+func writeFileOpError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errPathEscape):
+		writePathError(w, err)
+	case errors.Is(err, errRootEntry), errors.Is(err, errCopyIntoSelf), errors.Is(err, errSameEntry), errors.Is(err, errTargetIsParent):
+		writeErrorCode(w, http.StatusBadRequest, "invalid_path", err.Error())
+	case errors.Is(err, errConfirmRequired):
+		writeErrorCode(w, http.StatusConflict, "confirm_required", err.Error())
+	case errors.Is(err, fs.ErrNotExist):
+		writeErrorCode(w, http.StatusNotFound, "not_found", "file not found")
+	case errors.Is(err, syscall.ENOTEMPTY):
+		// 重要逻辑：ENOTEMPTY 同时满足 fs.ErrExist，需要先判断。
+		writeErrorCode(w, http.StatusConflict, "not_empty", "directory not empty")
+	case errors.Is(err, fs.ErrExist):
+		writeErrorCode(w, http.StatusConflict, "already_exists", "file already exists")
+	case errors.Is(err, fs.ErrPermission):
+		writeErrorCode(w, http.StatusForbidden, "permission_denied", "permission denied")
+	default:
+		writeErrorCode(w, http.StatusInternalServerError, "io_error", err.Error())
+	}
+}
+
+// HandleMakeDir 创建目录，parents 为 true 时自动创建上级目录。
+func HandleMakeDir(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload FilePathRequest
+		sessionID, root, ok := beginFileOp(w, r, manager, &payload)
+		if !ok {
+			return
+		}
+		target, err := resolveSafeEntry(root, payload.Path, manager.followSymlinks)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		if payload.Parents {
+			err = os.MkdirAll(target, 0o755)
+		} else {
+			err = os.Mkdir(target, 0o755)
+		}
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "file.mkdir", SessionID: sessionID, Path: target, Success: true})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "path": payload.Path})
+	}
+}
+
+// HandleCreateFile 创建空文件，文件已存在时报错。
+func HandleCreateFile(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload FilePathRequest
+		sessionID, root, ok := beginFileOp(w, r, manager, &payload)
+		if !ok {
+			return
+		}
+		target, err := resolveSafeEntry(root, payload.Path, manager.followSymlinks)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		_ = file.Close()
+		recordAudit(r, AuditEvent{Action: "file.create", SessionID: sessionID, Path: target, Success: true})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "path": payload.Path})
+	}
+}
+
+// HandleMoveFile 重命名或移动文件与目录。
+func HandleMoveFile(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload FileTransferRequest
+		sessionID, root, ok := beginFileOp(w, r, manager, &payload)
+		if !ok {
+			return
+		}
+		src, dst, err := resolveTransferPaths(root, payload, manager.followSymlinks)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		replaced, err := prepareTransferTarget(manager, r, root, src, dst, payload.Overwrite)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		// 重要逻辑：rename 会原子地替换已存在的文件目标，不会出现目标缺失的中间状态。
+		if err := os.Rename(src, dst); err != nil {
+			// 重要逻辑：跨文件系统无法直接 rename，退化为复制（经临时文件原子替换）后删除源文件。
+			if !errors.Is(err, syscall.EXDEV) {
+				writeFileOpError(w, err)
+				return
+			}
+			if err := copyTreeReplace(src, dst); err != nil {
+				writeFileOpError(w, err)
+				return
+			}
+			if err := os.RemoveAll(src); err != nil {
+				writeFileOpError(w, err)
+				return
+			}
+		}
+		recordAudit(r, AuditEvent{Action: "file.move", SessionID: sessionID, Path: src, Success: true, Detail: transferAuditDetail(dst, replaced)})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "from": payload.From, "to": payload.To})
+	}
+}
+
+// HandleCopyFile 复制文件或递归复制目录，符号链接按链接本身复制。
+func HandleCopyFile(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload FileTransferRequest
+		sessionID, root, ok := beginFileOp(w, r, manager, &payload)
+		if !ok {
+			return
+		}
+		src, dst, err := resolveTransferPaths(root, payload, manager.followSymlinks)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		replaced, err := prepareTransferTarget(manager, r, root, src, dst, payload.Overwrite)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		if err := copyTreeReplace(src, dst); err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "file.copy", SessionID: sessionID, Path: src, Success: true, Detail: transferAuditDetail(dst, replaced)})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "from": payload.From, "to": payload.To})
+	}
+}
+
+// HandleDeleteFile 将文件移入回收站（permanent 为 true 时直接删除）；
+// 非空目录需要同时指定 recursive 与 confirm。
+func HandleDeleteFile(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload FilePathRequest
+		sessionID, root, ok := beginFileOp(w, r, manager, &payload)
+		if !ok {
+			return
+		}
+		target, err := resolveSafeEntry(root, payload.Path, manager.followSymlinks)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		info, err := os.Lstat(target)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		recursive := false
+		if info.IsDir() {
+			children, err := os.ReadDir(target)
+			if err != nil {
+				writeFileOpError(w, err)
+				return
+			}
+			recursive = len(children) > 0
+		}
+		if recursive {
+			// 重要逻辑：递归删除必须显式确认，防止手机上误触删除整个目录。
+			if !payload.Recursive {
+				writeFileOpError(w, syscall.ENOTEMPTY)
+				return
+			}
+			if !payload.Confirm {
+				writeFileOpError(w, errConfirmRequired)
+				return
+			}
+		}
+
+		trashRoot := manager.trashRootFor(requestAccount(r), root)
+		// 重要逻辑：回收站内的条目以及显式要求时直接删除，其余移入回收站以便恢复。
+		if payload.Permanent || pathWithin(filepath.Join(trashRoot, trashDirName), target) {
+			if err := os.RemoveAll(target); err != nil {
+				writeFileOpError(w, err)
+				return
+			}
+			recordAudit(r, AuditEvent{Action: "file.delete", SessionID: sessionID, Path: target, Success: true, Detail: map[string]any{"recursive": recursive, "permanent": true}})
+			writeJSON(w, http.StatusOK, map[string]any{"ok": true, "path": payload.Path})
+			return
+		}
+		user := ""
+		if principal := requestPrincipal(r); principal != nil {
+			user = principal.Username
+		}
+		entry, err := moveToTrash(trashRoot, root, target, user, info.IsDir())
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "file.delete", SessionID: sessionID, Path: target, Success: true, Detail: map[string]any{"recursive": recursive, "trash_id": entry.ID}})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "path": payload.Path, "trash_id": entry.ID})
+	}
+}
+
+// resolveTransferPaths 解析移动/复制的源与目标路径，拒绝源与目标相同或互相包含的请求。
+func resolveTransferPaths(root string, payload FileTransferRequest, followSymlinks bool) (string, string, error) {
+	src, err := resolveSafeEntry(root, payload.From, followSymlinks)
+	if err != nil {
+		return "", "", err
+	}
+	dst, err := resolveSafeEntry(root, payload.To, followSymlinks)
+	if err != nil {
+		return "", "", err
+	}
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return "", "", err
+	}
+	// 重要逻辑：必须在处理 overwrite 之前检查，否则替换目标时会连同源一起删除。
+	if src == dst {
+		return "", "", errSameEntry
+	}
+	if dstInfo, err := os.Lstat(dst); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return "", "", errSameEntry
+	}
+	if pathWithin(src, dst) {
+		return "", "", errCopyIntoSelf
+	}
+	if pathWithin(dst, src) {
+		return "", "", errTargetIsParent
+	}
+	return src, dst, nil
+}
+
+// transferReplacement 记录 overwrite 时被替换的目标。
+type transferReplacement struct {
+	trashID string
+	inPlace bool
+}
+
+// prepareTransferTarget 检查目标是否已存在。overwrite 为 true 时：源与目标都不是目录则留给
+// rename 原子替换；否则把目标移入回收站（与删除一致，可恢复），返回被替换的信息。
+func prepareTransferTarget(manager *SessionManager, r *http.Request, root, src, dst string, overwrite bool) (*transferReplacement, error) {
+	dstInfo, err := os.Lstat(dst)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !overwrite {
+		return nil, fs.ErrExist
+	}
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return nil, err
+	}
+	if !dstInfo.IsDir() && !srcInfo.IsDir() {
+		return &transferReplacement{inPlace: true}, nil
+	}
+	user := ""
+	if principal := requestPrincipal(r); principal != nil {
+		user = principal.Username
+	}
+	entry, err := moveToTrash(manager.trashRootFor(requestAccount(r), root), root, dst, user, dstInfo.IsDir())
+	if err != nil {
+		return nil, err
+	}
+	return &transferReplacement{trashID: entry.ID}, nil
+}
+
+// transferAuditDetail 构造移动/复制的审计详情，记录被替换目标的去向。
+func transferAuditDetail(dst string, replaced *transferReplacement) map[string]any {
+	detail := map[string]any{"to": dst}
+	if replaced != nil {
+		detail["overwrite"] = true
+		if replaced.trashID != "" {
+			detail["trash_id"] = replaced.trashID
+		}
+	}
+	return detail
+}
+
+// copyTreeReplace 先复制到目标所在目录的临时位置，再 rename 到目标，目标已存在的文件会被原子替换，
+// 复制失败时不会留下不完整的目标。
+func copyTreeReplace(src, dst string) error {
+	stage, err := os.MkdirTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".copy-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+	staged := filepath.Join(stage, filepath.Base(dst))
+	if err := copyTree(src, staged); err != nil {
+		return err
+	}
+	return os.Rename(staged, dst)
+}
+
+// copyTree 递归复制文件、目录与符号链接，保留权限位。
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			// 重要逻辑：跳过设备、管道等特殊文件，避免复制时阻塞。
+			return nil
+		}
+	})
+}
+
+// copyFile 复制单个普通文件。
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -1,18 +1,34 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 const maxUploadSize = 50 * 1024 * 1024
 const maxPreviewSize = 1 * 1024 * 1024
 
+// WriteFileRequest 是保存文件的请求，expected_* 为读取文件时返回的版本信息。
+type WriteFileRequest struct {
+	Content         string `json:"content"`
+	ExpectedHash    string `json:"expected_hash"`
+	ExpectedMTimeNS int64  `json:"expected_mtime_ns"`
+	ExpectedSize    *int64 `json:"expected_size"`
+	Force           bool   `json:"force"`
+}
+
 // HandleFileTree 返回指定目录下的文件列表。
 func HandleFileTree(manager *SessionManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -25,15 +41,19 @@ func HandleFileTree(manager *SessionManager) http.HandlerFunc {
 			writeError(w, http.StatusBadRequest, "session_id required")
 			return
 		}
-		root, err := resolveSessionCWD(manager, sessionID)
+		root, browse, err := resolveFileRoot(r, manager, sessionID)
 		if err != nil {
 			writeError(w, http.StatusNotFound, err.Error())
 			return
 		}
 		relPath := r.URL.Query().Get("path")
-		target, err := resolveSafePath(root, relPath)
+		// 重要逻辑：未指定 path 时从会话当前目录开始浏览，根目录仍是整个工作区。
+		if !r.URL.Query().Has("path") {
+			relPath = browse
+		}
+		target, err := resolveSafePath(root, relPath, manager.followSymlinks)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+			writePathError(w, err)
 			return
 		}
 		entries, err := os.ReadDir(target)
@@ -43,6 +63,10 @@ func HandleFileTree(manager *SessionManager) http.HandlerFunc {
 		}
 		items := make([]map[string]any, 0, len(entries))
 		for _, entry := range entries {
+			// 重要逻辑：回收站目录通过专门的接口访问，不在文件树中展示。
+			if entry.Name() == trashDirName {
+				continue
+			}
 			info, infoErr := entry.Info()
 			if infoErr != nil {
 				continue
@@ -55,8 +79,12 @@ func HandleFileTree(manager *SessionManager) http.HandlerFunc {
 				"mtime":  info.ModTime().Unix(),
 			})
 		}
+		if relPath == "" {
+			relPath = "."
+		}
 		writeJSON(w, http.StatusOK, map[string]any{
 			"root":    root,
+			"path":    filepath.ToSlash(relPath),
 			"entries": items,
 		})
 	}
@@ -74,15 +102,15 @@ func HandleFileUpload(manager *SessionManager) http.HandlerFunc {
 			writeError(w, http.StatusBadRequest, "session_id required")
 			return
 		}
-		root, err := resolveSessionCWD(manager, sessionID)
+		root, _, err := resolveFileRoot(r, manager, sessionID)
 		if err != nil {
 			writeError(w, http.StatusNotFound, err.Error())
 			return
 		}
 		relPath := r.URL.Query().Get("path")
-		targetDir, err := resolveSafePath(root, relPath)
+		targetDir, err := resolveSafePath(root, relPath, manager.followSymlinks)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+			writePathError(w, err)
 			return
 		}
 		// 重要逻辑：限制上传大小，避免占满磁盘。
@@ -96,6 +124,14 @@ func HandleFileUpload(manager *SessionManager) http.HandlerFunc {
 			writeError(w, http.StatusBadRequest, "file required")
 			return
 		}
+		conflict, ok := parseConflictPolicy(r.URL.Query().Get("conflict"))
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid conflict policy")
+			return
+		}
+		target := uploadTarget{root: root, base: relPath, followSymlinks: manager.followSymlinks}
+		extract := queryBool(r, "extract", false)
+		saved := make([]UploadResult, 0)
 		for _, headers := range form.File {
 			for _, header := range headers {
 				if header == nil {
@@ -106,26 +142,41 @@ func HandleFileUpload(manager *SessionManager) http.HandlerFunc {
 					writeError(w, http.StatusBadRequest, "open file failed")
 					return
 				}
-				defer src.Close()
-				filename := filepath.Base(header.Filename)
-				destPath := filepath.Join(targetDir, filename)
-				dst, err := os.Create(destPath)
+				files, err := saveUploadPart(target, header, src, extract, conflict)
+				// 重要逻辑：每个文件处理完立即关闭，避免多文件上传时所有文件句柄保持到请求结束。
+				_ = src.Close()
 				if err != nil {
-					writeError(w, http.StatusInternalServerError, "save file failed")
-					return
-				}
-				if _, err := io.Copy(dst, src); err != nil {
-					_ = dst.Close()
-					writeError(w, http.StatusInternalServerError, "save file failed")
-					return
-				}
-				if err := dst.Close(); err != nil {
-					writeError(w, http.StatusInternalServerError, "save file failed")
+					saved = append(saved, files...)
+					// 重要逻辑：conflict=fail 遇到已存在的文件时停止，并返回此前已处理的文件。
+					if errors.Is(err, errUploadConflict) {
+						recordAudit(r, AuditEvent{Action: "file.upload", SessionID: sessionID, Path: targetDir, Success: false, Detail: map[string]any{"files": saved, "error": err.Error()}})
+						writeJSON(w, http.StatusConflict, map[string]any{"ok": false, "code": "already_exists", "message": err.Error(), "files": saved})
+						return
+					}
+					writeUploadError(w, err)
 					return
 				}
+				saved = append(saved, files...)
 			}
 		}
-		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+		recordAudit(r, AuditEvent{Action: "file.upload", SessionID: sessionID, Path: targetDir, Success: true, Detail: map[string]any{"files": saved, "extract": extract, "conflict": conflict}})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "files": saved})
+	}
+}
+
+// writeUploadError 写入上传失败的错误响应。
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errPathEscape):
+		writePathError(w, err)
+	case errors.Is(err, errUploadConflict):
+		writeErrorCode(w, http.StatusConflict, "already_exists", err.Error())
+	case errors.Is(err, errArchiveTooLarge):
+		writeErrorCode(w, http.StatusRequestEntityTooLarge, "too_large", err.Error())
+	case errors.Is(err, zip.ErrFormat), errors.Is(err, gzip.ErrHeader), errors.Is(err, tar.ErrHeader):
+		writeErrorCode(w, http.StatusBadRequest, "invalid_archive", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "save file failed")
 	}
 }
 
@@ -141,22 +192,39 @@ func HandleFileDownload(manager *SessionManager) http.HandlerFunc {
 			writeError(w, http.StatusBadRequest, "session_id required")
 			return
 		}
-		root, err := resolveSessionCWD(manager, sessionID)
+		root, _, err := resolveFileRoot(r, manager, sessionID)
 		if err != nil {
 			writeError(w, http.StatusNotFound, err.Error())
 			return
 		}
 		relPath := r.URL.Query().Get("path")
-		target, err := resolveSafePath(root, relPath)
+		target, err := resolveSafePath(root, relPath, manager.followSymlinks)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+			writePathError(w, err)
 			return
 		}
 		info, err := os.Stat(target)
-		if err != nil || info.IsDir() {
+		if err != nil {
 			writeError(w, http.StatusNotFound, "file not found")
 			return
 		}
+		if info.IsDir() {
+			// 重要逻辑：目录按 format 参数打包为 zip 或 tar.gz 流式下载。
+			format, ok := archiveFormat(r)
+			if !ok {
+				writeError(w, http.StatusBadRequest, "unsupported format")
+				return
+			}
+			err := streamDirectoryArchive(w, target, format, requestIgnoreMatcher(r, manager, false))
+			recordAudit(r, AuditEvent{Action: "file.download", SessionID: sessionID, Path: target, Success: err == nil, Detail: map[string]any{"format": format}})
+			if err != nil {
+				// 重要逻辑：响应已开始输出，只能中断连接让客户端感知压缩包不完整。
+				log.Printf("stream archive failed: %s: %v", target, err)
+				panic(http.ErrAbortHandler)
+			}
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "file.download", SessionID: sessionID, Path: target, Success: true, Detail: map[string]any{"size": info.Size()}})
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", info.Name()))
 		http.ServeFile(w, r, target)
 	}
@@ -174,15 +242,15 @@ func HandleFileRead(manager *SessionManager) http.HandlerFunc {
 			writeError(w, http.StatusBadRequest, "session_id required")
 			return
 		}
-		root, err := resolveSessionCWD(manager, sessionID)
+		root, _, err := resolveFileRoot(r, manager, sessionID)
 		if err != nil {
 			writeError(w, http.StatusNotFound, err.Error())
 			return
 		}
 		relPath := r.URL.Query().Get("path")
-		target, err := resolveSafePath(root, relPath)
+		target, err := resolveSafePath(root, relPath, manager.followSymlinks)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+			writePathError(w, err)
 			return
 		}
 		info, err := os.Stat(target)
@@ -195,49 +263,281 @@ func HandleFileRead(manager *SessionManager) http.HandlerFunc {
 			return
 		}
 		data, err := os.ReadFile(target)
+		if errors.Is(err, os.ErrPermission) {
+			writeFileOpError(w, err)
+			return
+		}
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, "read file failed")
 			return
 		}
+		recordAudit(r, AuditEvent{Action: "file.read", SessionID: sessionID, Path: target, Success: true})
+		writeJSON(w, http.StatusOK, map[string]any{
+			"path":     relPath,
+			"size":     info.Size(),
+			"count":    len(data),
+			"text":     string(data),
+			"mtime_ns": info.ModTime().UnixNano(),
+			"hash":     contentHash(data),
+		})
+	}
+}
+
+// HandleFileWrite 原子保存文本文件，并通过 hash 或 mtime/size 做乐观并发校验。
+func HandleFileWrite(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID := r.URL.Query().Get("session_id")
+		if sessionID == "" {
+			writeError(w, http.StatusBadRequest, "session_id required")
+			return
+		}
+		root, _, err := resolveFileRoot(r, manager, sessionID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		relPath := r.URL.Query().Get("path")
+		target, err := resolveSafePath(root, relPath, manager.followSymlinks)
+		if err != nil {
+			writePathError(w, err)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+		var payload WriteFileRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if !isDirectory(filepath.Dir(target)) {
+			writeError(w, http.StatusNotFound, "parent directory not found")
+			return
+		}
+
+		perm := os.FileMode(0o644)
+		info, statErr := os.Stat(target)
+		switch {
+		case statErr == nil && info.IsDir():
+			writeError(w, http.StatusBadRequest, "path is a directory")
+			return
+		case statErr == nil:
+			current, err := os.ReadFile(target)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "read file failed")
+				return
+			}
+			// 重要逻辑：文件已存在时必须携带读取时的版本信息，版本不一致说明已被其他人修改。
+			if !payload.Force && !payload.hasPrecondition() {
+				writeErrorCode(w, http.StatusPreconditionRequired, "precondition_required", "expected_hash or expected_mtime_ns required")
+				return
+			}
+			if !payload.Force && !payload.matches(info, current) {
+				writeJSON(w, http.StatusConflict, map[string]any{
+					"ok":       false,
+					"code":     "conflict",
+					"message":  "file changed since it was read",
+					"size":     info.Size(),
+					"mtime_ns": info.ModTime().UnixNano(),
+					"hash":     contentHash(current),
+				})
+				return
+			}
+			perm = info.Mode().Perm()
+		case errors.Is(statErr, os.ErrNotExist):
+			if payload.hasPrecondition() && !payload.Force {
+				writeErrorCode(w, http.StatusConflict, "conflict", "file no longer exists")
+				return
+			}
+		default:
+			writeError(w, http.StatusInternalServerError, "stat file failed")
+			return
+		}
+
+		data := []byte(payload.Content)
+		if err := writeFileAtomic(target, data, perm); err != nil {
+			writeError(w, http.StatusInternalServerError, "write file failed")
+			return
+		}
+		if statErr == nil {
+			preserveOwner(target, info)
+		}
+		saved, err := os.Stat(target)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "stat file failed")
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "file.write", SessionID: sessionID, Path: target, Success: true, Detail: map[string]any{"size": len(data)}})
 		writeJSON(w, http.StatusOK, map[string]any{
-			"path":  relPath,
-			"size":  info.Size(),
-			"count": len(data),
-			"text":  string(data),
+			"ok":       true,
+			"path":     relPath,
+			"size":     saved.Size(),
+			"mtime_ns": saved.ModTime().UnixNano(),
+			"hash":     contentHash(data),
 		})
 	}
 }
 
+// hasPrecondition 判断请求是否携带了版本信息。
+func (p WriteFileRequest) hasPrecondition() bool {
+	return p.ExpectedHash != "" || p.ExpectedMTimeNS != 0
+}
+
+// matches 判断文件当前版本是否与请求携带的版本一致，优先比较内容哈希。
+func (p WriteFileRequest) matches(info os.FileInfo, current []byte) bool {
+	if p.ExpectedHash != "" {
+		return p.ExpectedHash == contentHash(current)
+	}
+	if p.ExpectedSize != nil && *p.ExpectedSize != info.Size() {
+		return false
+	}
+	return p.ExpectedMTimeNS == info.ModTime().UnixNano()
+}
+
+// contentHash 计算文件内容的 SHA-256 十六进制摘要。
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// preserveOwner 保持覆盖写入后文件的属主不变（以 root 运行时编辑他人文件）。
+func preserveOwner(path string, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	_ = os.Lchown(path, int(stat.Uid), int(stat.Gid))
+}
+
 // resolveSessionCWD 获取会话对应的工作目录。
-func resolveSessionCWD(manager *SessionManager, sessionID string) (string, error) {
-	session, ok := manager.GetSession(sessionID)
-	if !ok || session == nil || session.Cmd == nil || session.Cmd.Process == nil {
+func resolveSessionCWD(r *http.Request, manager *SessionManager, sessionID string) (string, error) {
+	session, ok := authorizeSession(r, manager, sessionID)
+	if !ok || session.Cmd == nil || session.Cmd.Process == nil {
 		return "", errors.New("session not found")
 	}
 	pid := session.Cmd.Process.Pid
 	if pid == 0 {
 		return "", errors.New("session not found")
 	}
-	// 重要逻辑：通过 /proc 获取进程当前目录。
-	cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
-	if err != nil {
-		return "", errors.New("cannot resolve session cwd")
+	session.mu.Lock()
+	cwd := session.CWD
+	reported := session.cwdReported
+	ptmx := session.PTY
+	session.mu.Unlock()
+	// 重要逻辑：优先使用 shell 通过 OSC 7 上报的目录，子 shell/sudo 场景下仍然准确。
+	if reported && isDirectory(cwd) {
+		return cwd, nil
+	}
+	// 重要逻辑：没有上报时回退到前台进程组，再回退到顶层 shell。
+	candidates := []int{pid}
+	if pgid, err := foregroundPGID(ptmx); err == nil && pgid > 0 && pgid != pid {
+		candidates = []int{pgid, pid}
 	}
-	return cwd, nil
+	for _, candidate := range candidates {
+		resolved, err := readProcCWD(candidate)
+		if err != nil {
+			continue
+		}
+		session.mu.Lock()
+		session.CWD = resolved
+		session.mu.Unlock()
+		return resolved, nil
+	}
+	if cwd != "" {
+		return cwd, nil
+	}
+	return "", errors.New("cannot resolve session cwd")
 }
 
+// isDirectory 判断路径是否为存在的目录。
+func isDirectory(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// errPathEscape 表示请求路径（含符号链接解析后）超出了允许访问的根目录。
+var errPathEscape = errors.New("path escapes root")
+
 // resolveSafePath 将相对路径安全拼接到根目录。
-func resolveSafePath(root, rel string) (string, error) {
+// followSymlinks 为 false 时会解析符号链接，确保最终目标仍在根目录内，并返回解析后的真实路径。
+func resolveSafePath(root, rel string, followSymlinks bool) (string, error) {
 	if filepath.IsAbs(rel) {
 		return "", errors.New("absolute path not allowed")
 	}
 	clean := filepath.Clean(rel)
-	if clean == "." {
-		return root, nil
-	}
-	if strings.HasPrefix(clean, "..") {
-		return "", errors.New("invalid path")
+	if clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errPathEscape
 	}
 	target := filepath.Join(root, clean)
-	return target, nil
+	if followSymlinks {
+		return target, nil
+	}
+	realRoot, err := resolveRealPath(root)
+	if err != nil {
+		return "", err
+	}
+	realTarget, err := resolveRealPath(target)
+	if err != nil {
+		return "", err
+	}
+	// 重要逻辑：根目录内指向 /etc、~/.ssh 等位置的符号链接会在这里被拦截。
+	if !pathWithin(realRoot, realTarget) {
+		return "", errPathEscape
+	}
+	return realTarget, nil
+}
+
+// resolveRealPath 解析路径中的符号链接；路径末尾不存在的部分原样拼接，
+// 悬空的符号链接也会被解析，避免创建文件时写到链接指向的外部位置。
+func resolveRealPath(path string) (string, error) {
+	for depth := 0; depth < 40; depth++ {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return real, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if info, statErr := os.Lstat(path); statErr == nil && info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(filepath.Dir(path), link)
+			}
+			path = link
+			continue
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path, nil
+		}
+		realParent, err := resolveRealPath(parent)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(realParent, filepath.Base(path)), nil
+	}
+	return "", errors.New("too many levels of symbolic links")
+}
+
+// pathWithin 判断 target 是否位于 root 目录内（含 root 本身）。
+func pathWithin(root, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// writePathError 写入路径解析错误，越界访问使用独立的错误码。
+func writePathError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPathEscape) {
+		writeErrorCode(w, http.StatusForbidden, "path_escape", err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newEscapeFixture 创建根目录与其外部的目录，根目录内放置指向外部的符号链接，返回两者的真实路径。
+func newEscapeFixture(t *testing.T) (string, string) {
+	t.Helper()
+	base, err := resolveRealPath(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{filepath.Join(root, "sub", "file.txt"), filepath.Join(outside, "secret")} {
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"link":      outside,
+		"file-link": filepath.Join(outside, "secret"),
+		"dangling":  filepath.Join(outside, "missing"),
+		"inner":     filepath.Join(root, "sub"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+// TestResolveSafePathSymlinkEscape 确认经符号链接（包括悬空链接）指向根目录之外的路径被拒绝，根目录内的链接正常解析。
+func TestResolveSafePathSymlinkEscape(t *testing.T) {
+	root, outside := newEscapeFixture(t)
+	cases := []struct {
+		rel    string
+		follow bool
+		want   string
+		err    error
+	}{
+		{rel: "sub/file.txt", want: filepath.Join(root, "sub", "file.txt")},
+		{rel: "sub/new.txt", want: filepath.Join(root, "sub", "new.txt")},
+		{rel: "inner/file.txt", want: filepath.Join(root, "sub", "file.txt")},
+		{rel: "link/secret", err: errPathEscape},
+		{rel: "link", err: errPathEscape},
+		{rel: "file-link", err: errPathEscape},
+		{rel: "dangling", err: errPathEscape},
+		{rel: "../outside/secret", err: errPathEscape},
+		{rel: "sub/../../outside/secret", err: errPathEscape},
+		{rel: "link/secret", follow: true, want: filepath.Join(root, "link", "secret")},
+	}
+	for _, tc := range cases {
+		got, err := resolveSafePath(root, filepath.FromSlash(tc.rel), tc.follow)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("resolveSafePath(%q, follow=%v) error = %v, want %v", tc.rel, tc.follow, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Fatalf("resolveSafePath(%q, follow=%v) = %q, %v, want %q", tc.rel, tc.follow, got, err, tc.want)
+		}
+	}
+	if _, err := resolveSafePath(root, filepath.Join(outside, "secret"), false); err == nil {
+		t.Fatalf("absolute path accepted")
+	}
+}
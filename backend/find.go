@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultFindLimit = 50
+	maxFindLimit     = 500
+	maxFindVisited   = 200000
+	findTimeBudget   = 2 * time.Second
+)
+
+var errFindBudget = errors.New("find budget exceeded")
+
+// FindResult 是文件名搜索的单条结果，Path 相对根目录。
+type FindResult struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	IsDir bool   `json:"is_dir"`
+	Score int    `json:"score"`
+}
+
+// fuzzyScore 计算 pattern 作为子序列匹配 candidate 的得分，连续字符与分段开头的匹配得分更高。
+func fuzzyScore(pattern, candidate string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+	score := 0
+	last := -1
+	index := 0
+	for _, want := range pattern {
+		found := -1
+		for i, got := range candidate[index:] {
+			if got == want {
+				found = index + i
+				break
+			}
+		}
+		if found < 0 {
+			return 0, false
+		}
+		score++
+		if last >= 0 && found == last+utf8.RuneLen(want) {
+			score += 5
+		} else if last >= 0 {
+			// 重要逻辑：跳过的字符越多扣分越多，但设上限，避免长路径被过度惩罚。
+			gap := found - last
+			if gap > 10 {
+				gap = 10
+			}
+			score -= gap / 2
+		}
+		if found == 0 || strings.ContainsRune("/_-. ", rune(candidate[found-1])) {
+			score += 8
+		}
+		last = found
+		index = found + utf8.RuneLen(want)
+	}
+	if strings.Contains(candidate, pattern) {
+		score += 2 * len(pattern)
+	}
+	return score, true
+}
+
+// scoreFindCandidate 对相对路径打分，每个关键词都需匹配；命中文件名的优先于只命中目录部分的。
+func scoreFindCandidate(terms []string, rel string) (int, bool) {
+	lowerPath := strings.ToLower(rel)
+	lowerName := strings.ToLower(filepath.Base(rel))
+	total := 0
+	for _, term := range terms {
+		best, ok := fuzzyScore(term, lowerPath)
+		if !ok {
+			return 0, false
+		}
+		if score, ok := fuzzyScore(term, lowerName); ok {
+			score += 10
+			if strings.HasPrefix(lowerName, term) {
+				score += 15
+			}
+			if lowerName == term {
+				score += 20
+			}
+			if score > best {
+				best = score
+			}
+		}
+		total += best
+	}
+	// 重要逻辑：得分相同时浅层、短路径优先。
+	return total - strings.Count(rel, "/") - len(rel)/20, true
+}
+
+// sortFindResults 按得分从高到低排序，得分相同按路径排序。
+func sortFindResults(results []FindResult) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+}
+
+// HandleFindFiles 在根目录下按文件名模糊搜索，默认遵循 .gitignore 与排除列表，并限制结果数量与耗时。
+func HandleFindFiles(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID := r.URL.Query().Get("session_id")
+		if sessionID == "" {
+			writeError(w, http.StatusBadRequest, "session_id required")
+			return
+		}
+		terms := strings.Fields(strings.ToLower(r.URL.Query().Get("q")))
+		if len(terms) == 0 {
+			writeError(w, http.StatusBadRequest, "q required")
+			return
+		}
+		limit := defaultFindLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			if parsed < maxFindLimit {
+				limit = parsed
+			} else {
+				limit = maxFindLimit
+			}
+		}
+		root, _, err := resolveFileRoot(r, manager, sessionID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		relPath := r.URL.Query().Get("path")
+		target, err := resolveSafePath(root, relPath, manager.followSymlinks)
+		if err != nil {
+			writePathError(w, err)
+			return
+		}
+
+		deadline := time.Now().Add(findTimeBudget)
+		visited := 0
+		results := make([]FindResult, 0, limit)
+		err = walkWorkspace(target, requestIgnoreMatcher(r, manager, true), func(abs, rel string, entry fs.DirEntry) error {
+			if rel == "." {
+				return nil
+			}
+			// 重要逻辑：超过耗时或遍历数量上限时停止，返回已找到的结果并标记 truncated。
+			visited++
+			if visited > maxFindVisited || (visited%1000 == 0 && (time.Now().After(deadline) || r.Context().Err() != nil)) {
+				return errFindBudget
+			}
+			score, ok := scoreFindCandidate(terms, filepath.ToSlash(rel))
+			if !ok {
+				return nil
+			}
+			results = append(results, FindResult{
+				Name:  entry.Name(),
+				Path:  filepath.ToSlash(filepath.Join(relPath, rel)),
+				IsDir: entry.IsDir(),
+				Score: score,
+			})
+			// 重要逻辑：只保留得分最高的一批结果，避免宽泛的查询占用大量内存。
+			if len(results) >= 4*limit {
+				sortFindResults(results)
+				results = results[:limit]
+			}
+			return nil
+		})
+		truncated := errors.Is(err, errFindBudget)
+		if err != nil && !truncated {
+			writeError(w, http.StatusInternalServerError, "read dir failed")
+			return
+		}
+		sortFindResults(results)
+		if len(results) > limit {
+			results = results[:limit]
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"root":      root,
+			"items":     results,
+			"truncated": truncated,
+		})
+	}
+}
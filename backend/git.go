@@ -22,17 +22,20 @@ func HandleGitStatus(manager *SessionManager) http.HandlerFunc {
 			writeError(w, http.StatusBadRequest, "session_id required")
 			return
 		}
-		sessionCWD, err := resolveSessionCWD(manager, sessionID)
+		fileRoot, browse, err := resolveFileRoot(r, manager, sessionID)
 		if err != nil {
 			writeError(w, http.StatusNotFound, err.Error())
 			return
 		}
-		root, err := resolveGitRoot(sessionCWD)
-		if err != nil {
+		// 重要逻辑：从会话当前目录向上查找仓库，工作区根下的子仓库同样可用。
+		base := filepath.Join(fileRoot, filepath.FromSlash(browse))
+		account := requestAccount(r)
+		root, err := resolveGitRoot(account, base)
+		if err != nil || !manager.gitRootAllowed(root) {
 			writeError(w, http.StatusNotFound, "git status failed")
 			return
 		}
-		cmd := exec.Command("git", "-C", root, "status", "--porcelain=v1", "-z")
+		cmd := accountCommand(account, "git", "-C", root, "status", "--porcelain=v1", "-z")
 		output, err := cmd.Output()
 		if err != nil {
 			writeError(w, http.StatusNotFound, "git status failed")
@@ -44,7 +47,7 @@ func HandleGitStatus(manager *SessionManager) http.HandlerFunc {
 			if !ok || path == "" {
 				continue
 			}
-			additions, deletions := gitDiffStatForPath(root, path)
+			additions, deletions := gitDiffStatForPath(account, root, path)
 			item["additions"] = additions
 			item["deletions"] = deletions
 		}
@@ -69,21 +72,30 @@ func HandleGitDiff(manager *SessionManager) http.HandlerFunc {
 			writeError(w, http.StatusBadRequest, "path required")
 			return
 		}
-		sessionCWD, err := resolveSessionCWD(manager, sessionID)
+		fileRoot, browse, err := resolveFileRoot(r, manager, sessionID)
 		if err != nil {
 			writeError(w, http.StatusNotFound, err.Error())
 			return
 		}
-		root, err := resolveGitRoot(sessionCWD)
-		if err != nil {
+		// 重要逻辑：从会话当前目录向上查找仓库，工作区根下的子仓库同样可用。
+		base := filepath.Join(fileRoot, filepath.FromSlash(browse))
+		account := requestAccount(r)
+		root, err := resolveGitRoot(account, base)
+		if err != nil || !manager.gitRootAllowed(root) {
 			writeError(w, http.StatusNotFound, "git diff failed")
 			return
 		}
-		diff, err := gitDiffForPath(root, path)
+		// 重要逻辑：未跟踪文件会走 no-index diff，必须校验路径不越出仓库，否则可读取任意文件。
+		if _, err := resolveSafePath(root, filepath.FromSlash(normalizeGitPath(root, path)), manager.followSymlinks); err != nil {
+			writePathError(w, err)
+			return
+		}
+		diff, err := gitDiffForPath(account, root, path)
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, "git diff failed")
 			return
 		}
+		recordAudit(r, AuditEvent{Action: "git.diff", SessionID: sessionID, Path: filepath.Join(root, path), Success: true})
 		writeJSON(w, http.StatusOK, map[string]any{"diff": diff})
 	}
 }
@@ -120,27 +132,27 @@ func parseGitStatus(output []byte) []map[string]any {
 }
 
 // gitDiffForPath 获取指定路径的 diff 文本。
-func gitDiffForPath(root, path string) (string, error) {
+func gitDiffForPath(account *RunAsAccount, root, path string) (string, error) {
 	normalized := normalizeGitPath(root, path)
-	if isTrackedGitFile(root, normalized) {
-		output, err := runGitDiff("git", "-C", root, "diff", "--", normalized)
+	if isTrackedGitFile(account, root, normalized) {
+		output, err := runGitDiff(accountCommand(account, "git", "-C", root, "diff", "--", normalized))
 		return output, err
 	}
 	// 重要逻辑：未跟踪文件使用 no-index diff。
-	output, err := runGitDiff("git", "-C", root, "diff", "--no-index", "--", "/dev/null", normalized)
+	output, err := runGitDiff(accountCommand(account, "git", "-C", root, "diff", "--no-index", "--", "/dev/null", normalized))
 	return output, err
 }
 
 // gitDiffStatForPath 获取指定路径的增删行统计。
-func gitDiffStatForPath(root, path string) (int, int) {
+func gitDiffStatForPath(account *RunAsAccount, root, path string) (int, int) {
 	normalized := normalizeGitPath(root, path)
 	var output string
 	var err error
-	if isTrackedGitFile(root, normalized) {
-		output, err = runGitDiff("git", "-C", root, "diff", "--numstat", "--", normalized)
+	if isTrackedGitFile(account, root, normalized) {
+		output, err = runGitDiff(accountCommand(account, "git", "-C", root, "diff", "--numstat", "--", normalized))
 	} else {
 		// 重要逻辑：未跟踪文件使用 no-index 统计，保证新增文件也能返回行数。
-		output, err = runGitDiff("git", "-C", root, "diff", "--numstat", "--no-index", "--", "/dev/null", normalized)
+		output, err = runGitDiff(accountCommand(account, "git", "-C", root, "diff", "--numstat", "--no-index", "--", "/dev/null", normalized))
 	}
 	if err != nil {
 		return 0, 0
@@ -180,8 +192,8 @@ func parseNumStatValue(value string) int {
 }
 
 // isTrackedGitFile 判断文件是否在 Git 索引中。
-func isTrackedGitFile(root, path string) bool {
-	cmd := exec.Command("git", "-C", root, "ls-files", "--error-unmatch", "--", path)
+func isTrackedGitFile(account *RunAsAccount, root, path string) bool {
+	cmd := accountCommand(account, "git", "-C", root, "ls-files", "--error-unmatch", "--", path)
 	return cmd.Run() == nil
 }
 
@@ -205,8 +217,8 @@ func normalizeGitPath(root, path string) string {
 }
 
 // resolveGitRoot 获取指定目录所在的 Git 仓库根目录。
-func resolveGitRoot(cwd string) (string, error) {
-	cmd := exec.Command("git", "-C", cwd, "rev-parse", "--show-toplevel")
+func resolveGitRoot(account *RunAsAccount, cwd string) (string, error) {
+	cmd := accountCommand(account, "git", "-C", cwd, "rev-parse", "--show-toplevel")
 	output, err := cmd.Output()
 	if err != nil {
 		return "", err
@@ -215,8 +227,7 @@ func resolveGitRoot(cwd string) (string, error) {
 }
 
 // runGitDiff 执行 git diff 并容忍差异导致的退出码。
-func runGitDiff(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
+func runGitDiff(cmd *exec.Cmd) (string, error) {
 	output, err := cmd.CombinedOutput()
 	if err == nil {
 		return string(output), nil
@@ -19,3 +19,12 @@ func writeError(w http.ResponseWriter, status int, message string) {
 		"message": message,
 	})
 }
+
+// writeErrorCode 写入带错误码的错误响应，便于前端区分处理。
+func writeErrorCode(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]any{
+		"ok":      false,
+		"code":    code,
+		"message": message,
+	})
+}
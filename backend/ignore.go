@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// defaultExcludes 是遍历目录时默认跳过的依赖与缓存目录。
+var defaultExcludes = []string{
+	".git", ".hg", ".svn", trashDirName, "node_modules", "__pycache__",
+	".venv", "venv", ".tox", ".cache", ".next", ".DS_Store",
+}
+
+// ignoreRule 是一条 .gitignore 规则，base 为规则所在目录（相对匹配基准目录，见 ignoreMatcher.prefix）。
+type ignoreRule struct {
+	base     string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// ignoreMatcher 判断遍历时哪些路径需要跳过。
+// prefix 为遍历根目录相对 git 仓库根目录的路径，规则与路径都换算到仓库根目录下匹配。
+type ignoreMatcher struct {
+	excludes  map[string]bool
+	gitignore bool
+	prefix    string
+	rules     []ignoreRule
+}
+
+// newIgnoreMatcher 创建匹配器，gitignore 为 true 时遍历过程中加载各级 .gitignore。
+func newIgnoreMatcher(excludes []string, gitignore bool) *ignoreMatcher {
+	matcher := &ignoreMatcher{excludes: make(map[string]bool, len(excludes)), gitignore: gitignore}
+	for _, name := range excludes {
+		matcher.excludes[name] = true
+	}
+	return matcher
+}
+
+// LoadParents 在遍历子目录前，从所在 git 仓库根目录起逐级加载 dir 上级目录中的 .gitignore，
+// 使下载或搜索子目录时与在仓库根目录遍历的结果一致；不在仓库内时不做处理。
+func (m *ignoreMatcher) LoadParents(dir string) {
+	if !m.gitignore {
+		return
+	}
+	gitRoot, ok := findGitWorkTree(dir)
+	if !ok || gitRoot == dir {
+		return
+	}
+	rel, err := filepath.Rel(gitRoot, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return
+	}
+	m.prefix = filepath.ToSlash(rel)
+	// 重要逻辑：只加载 dir 的上级目录，dir 自身的 .gitignore 由遍历时的 LoadDir 加载。
+	current := gitRoot
+	m.loadRules(current, "")
+	segments := strings.Split(m.prefix, "/")
+	for i, segment := range segments[:len(segments)-1] {
+		current = filepath.Join(current, segment)
+		m.loadRules(current, strings.Join(segments[:i+1], "/"))
+	}
+}
+
+// findGitWorkTree 自 dir 向上查找包含 .git 的目录，作为 git 仓库根目录。
+func findGitWorkTree(dir string) (string, bool) {
+	current := filepath.Clean(dir)
+	for {
+		if _, err := os.Lstat(filepath.Join(current, ".git")); err == nil {
+			return current, true
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return "", false
+		}
+		current = parent
+	}
+}
+
+// LoadDir 读取目录下的 .gitignore，rel 为该目录相对遍历根目录的路径。
+func (m *ignoreMatcher) LoadDir(abs, rel string) {
+	if !m.gitignore {
+		return
+	}
+	m.loadRules(abs, m.fullPath(rel))
+}
+
+// fullPath 将相对遍历根目录的路径换算为相对仓库根目录的路径，根目录本身返回空字符串。
+func (m *ignoreMatcher) fullPath(rel string) string {
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+	switch {
+	case m.prefix == "":
+		return rel
+	case rel == "":
+		return m.prefix
+	default:
+		return m.prefix + "/" + rel
+	}
+}
+
+// loadRules 解析 abs 目录下的 .gitignore，base 为该目录相对仓库根目录的路径。
+func (m *ignoreMatcher) loadRules(abs, base string) {
+	file, err := os.Open(filepath.Join(abs, ".gitignore"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\")
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// 重要逻辑：开头或中间带 / 的规则相对所在目录匹配，否则匹配任意层级的名称。
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		m.rules = append(m.rules, rule)
+	}
+}
+
+// Ignored 判断相对路径是否需要跳过；目录被跳过时其子项不会再被访问。
+func (m *ignoreMatcher) Ignored(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	if m.excludes[path.Base(rel)] {
+		return true
+	}
+	rel = m.fullPath(rel)
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if rule.base != "" {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			sub = strings.TrimPrefix(rel, rule.base+"/")
+		}
+		var matched bool
+		if rule.anchored {
+			matched = globMatch(strings.Split(rule.pattern, "/"), strings.Split(sub, "/"))
+		} else {
+			matched, _ = path.Match(rule.pattern, path.Base(sub))
+		}
+		// 重要逻辑：与 git 一致，后出现的规则覆盖前面的结果。
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// globMatch 按路径段匹配，** 可匹配零个或多个路径段。
+func globMatch(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if globMatch(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return globMatch(pattern[1:], segments[1:])
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// TestWalkSubdirAppliesParentGitignore 确认遍历仓库子目录时会应用仓库根目录到该目录之间的 .gitignore。
+func TestWalkSubdirAppliesParentGitignore(t *testing.T) {
+	repo := t.TempDir()
+	files := map[string]string{
+		".gitignore":           "*.log\n/sub/dist/\n",
+		"sub/.gitignore":       "tmp/\n",
+		"sub/app/a.log":        "",
+		"sub/app/keep.txt":     "",
+		"sub/app/dist/out.txt": "",
+		"sub/dist/out.txt":     "",
+		"sub/app/tmp/x.txt":    "",
+	}
+	if err := os.Mkdir(filepath.Join(repo, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(repo, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	err := walkWorkspace(filepath.Join(repo, "sub", "app"), newIgnoreMatcher(nil, true), func(abs, rel string, entry fs.DirEntry) error {
+		if !entry.IsDir() {
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	// /sub/dist/ 锚定在仓库根目录，不影响 sub/app/dist。
+	want := []string{"dist/out.txt", "keep.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("walked files = %q, want %q", got, want)
+	}
+}
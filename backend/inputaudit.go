@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"sync"
+)
+
+const maxAuditInputLine = 4096
+
+// inputRecorder 将终端输入按行写入审计日志，读取密码等隐藏输入的内容不会被记录。
+// 在 shell 提示符处输入的命令由 OSC 133/633 标记另行记录为 session.command。
+type inputRecorder struct {
+	mu       sync.Mutex
+	request  *http.Request
+	session  *Session
+	line     []byte
+	redacted bool
+}
+
+// newInputRecorder 在开启输入审计时创建记录器，未开启时返回 nil。
+func newInputRecorder(r *http.Request, session *Session) *inputRecorder {
+	audit, ok := r.Context().Value(auditLoggerKey{}).(*AuditLogger)
+	if !ok || !audit.CaptureInput() {
+		return nil
+	}
+	return &inputRecorder{request: r, session: session}
+}
+
+// Write 记录一次写入 PTY 的输入，遇到回车时按行落盘。
+func (rec *inputRecorder) Write(ptmx *os.File, data []byte) {
+	if rec == nil {
+		return
+	}
+	// 重要逻辑：按写入时的终端状态判断，读取密码时只标记脱敏不保留明文；
+	// 若 shell 集成标记表明正处于提示符，直接丢弃，命令由 session.command 另行记录。
+	hidden := terminalInputHidden(ptmx, rec.session.Cmd.Process.Pid)
+	rec.session.mu.Lock()
+	atPrompt := rec.session.cmdParser.AtPrompt()
+	rec.session.mu.Unlock()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, b := range data {
+		switch {
+		case b == '\r' || b == '\n':
+			rec.flushLocked()
+		case atPrompt:
+			rec.line = rec.line[:0]
+		case hidden:
+			rec.redacted = true
+		case b == 0x7f || b == 0x08:
+			if len(rec.line) > 0 {
+				rec.line = rec.line[:len(rec.line)-1]
+			}
+		case len(rec.line) < maxAuditInputLine:
+			rec.line = append(rec.line, b)
+		}
+	}
+}
+
+// Flush 写出尚未以回车结束的输入，连接断开时调用。
+func (rec *inputRecorder) Flush() {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.flushLocked()
+}
+
+func (rec *inputRecorder) flushLocked() {
+	if len(rec.line) == 0 && !rec.redacted {
+		return
+	}
+	detail := map[string]any{"input": string(rec.line)}
+	if rec.redacted {
+		detail["redacted"] = true
+	}
+	// 重要逻辑：开启 shell 集成（OSC 7 上报目录）时附带命令执行目录，便于还原命令历史。
+	rec.session.mu.Lock()
+	if rec.session.cwdReported {
+		detail["cwd"] = rec.session.CWD
+	}
+	rec.session.mu.Unlock()
+	recordAudit(rec.request, AuditEvent{Action: "session.input", SessionID: rec.session.ID, Success: true, Detail: detail})
+	rec.line = rec.line[:0]
+	rec.redacted = false
+}
+
+// trackSessionCommands 从输出中解析 OSC 133/633 命令标记，把执行的命令写入审计日志。
+func trackSessionCommands(session *Session, data []byte) {
+	if !session.audit.CaptureInput() {
+		return
+	}
+	session.mu.Lock()
+	commands := session.cmdParser.Feed(data)
+	cwd := ""
+	if session.cwdReported {
+		cwd = session.CWD
+	}
+	session.mu.Unlock()
+	for _, command := range commands {
+		detail := map[string]any{"command": command}
+		if cwd != "" {
+			detail["cwd"] = cwd
+		}
+		session.audit.Record(AuditEvent{Action: "session.command", User: session.Owner, SessionID: session.ID, Success: true, Detail: detail})
+	}
+}
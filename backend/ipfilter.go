@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// ClientIPResolver 解析请求的真实客户端 IP，仅信任来自可信代理的 X-Forwarded-For。
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+// NewClientIPResolver 根据可信代理网段创建 ClientIPResolver。
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	trusted, err := parseCIDRList(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientIPResolver{trusted: trusted}, nil
+}
+
+// ClientIP 返回请求的客户端 IP。
+func (c *ClientIPResolver) ClientIP(r *http.Request) net.IP {
+	remote := net.ParseIP(hostWithoutPort(r.RemoteAddr))
+	if remote == nil || !ipInNets(remote, c.trusted) {
+		return remote
+	}
+	// 重要逻辑：从右往左跳过可信代理，第一个不可信地址才是客户端，防止伪造最左侧地址。
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		remote = ip
+		if !ipInNets(ip, c.trusted) {
+			break
+		}
+	}
+	return remote
+}
+
+// withIPFilter 记录客户端 IP，并拒绝不在允许网段内的请求。
+func withIPFilter(resolver *ClientIPResolver, allowed []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := resolver.ClientIP(r)
+		if len(allowed) > 0 && (ip == nil || !ipInNets(ip, allowed)) {
+			writeError(w, http.StatusForbidden, "client ip not allowed")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// requestClientIP 返回 withIPFilter 解析出的客户端 IP 字符串。
+func requestClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(net.IP); ok && ip != nil {
+		return ip.String()
+	}
+	return hostWithoutPort(r.RemoteAddr)
+}
+
+// parseCIDRList 解析网段列表，单个 IP 视为 /32 或 /128。
+func parseCIDRList(items []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q", item)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ipInNets 判断 IP 是否属于任一网段。
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ResourceLimits 描述会话进程的资源限制，零值表示不限制。
+type ResourceLimits struct {
+	MaxMemory      int64 `json:"max_memory,omitempty"`
+	CPUPercent     int   `json:"cpu_percent,omitempty"`
+	MaxProcesses   int   `json:"max_processes,omitempty"`
+	MaxOpenFiles   int   `json:"max_open_files,omitempty"`
+	TimeoutSeconds int   `json:"timeout_seconds,omitempty"`
+}
+
+// ResourceUsage 描述会话进程当前的资源占用。
+type ResourceUsage struct {
+	MemoryBytes int64   `json:"memory_bytes"`
+	CPUSeconds  float64 `json:"cpu_seconds"`
+	Processes   int     `json:"processes"`
+	Source      string  `json:"source"`
+}
+
+// errLimitExceedsCap 表示请求的资源限制超过了服务端配置的上限。
+var errLimitExceedsCap = errors.New("limit exceeds configured cap")
+
+// Merge 用 override 中非零的字段收紧当前限制，已配置的上限不会被放宽。
+func (l ResourceLimits) Merge(override ResourceLimits) ResourceLimits {
+	l.MaxMemory = tightenLimit(l.MaxMemory, override.MaxMemory)
+	l.CPUPercent = int(tightenLimit(int64(l.CPUPercent), int64(override.CPUPercent)))
+	l.MaxProcesses = int(tightenLimit(int64(l.MaxProcesses), int64(override.MaxProcesses)))
+	l.MaxOpenFiles = int(tightenLimit(int64(l.MaxOpenFiles), int64(override.MaxOpenFiles)))
+	l.TimeoutSeconds = int(tightenLimit(int64(l.TimeoutSeconds), int64(override.TimeoutSeconds)))
+	return l
+}
+
+// CheckWithin 校验 override 中的每项限制都不超过 l 中已配置的上限。
+func (l ResourceLimits) CheckWithin(override ResourceLimits) error {
+	checks := []struct {
+		name      string
+		cap, want int64
+	}{
+		{"max_memory", l.MaxMemory, override.MaxMemory},
+		{"cpu_percent", int64(l.CPUPercent), int64(override.CPUPercent)},
+		{"max_processes", int64(l.MaxProcesses), int64(override.MaxProcesses)},
+		{"max_open_files", int64(l.MaxOpenFiles), int64(override.MaxOpenFiles)},
+		{"timeout_seconds", int64(l.TimeoutSeconds), int64(override.TimeoutSeconds)},
+	}
+	for _, check := range checks {
+		if check.cap > 0 && check.want > check.cap {
+			return fmt.Errorf("%w: %s", errLimitExceedsCap, check.name)
+		}
+	}
+	return nil
+}
+
+// tightenLimit 返回配置值与请求值中更严格的一个，零值表示不限制。
+func tightenLimit(configured, requested int64) int64 {
+	if requested <= 0 {
+		return configured
+	}
+	if configured > 0 && configured < requested {
+		return configured
+	}
+	return requested
+}
+
+// IsZero 判断是否未设置任何限制。
+func (l ResourceLimits) IsZero() bool {
+	return l == ResourceLimits{}
+}
+
+// applyRlimits 通过 prlimit 为已启动的进程设置 rlimit，子进程会继承。
+func applyRlimits(pid int, limits ResourceLimits, hasCgroup bool) error {
+	if limits.MaxOpenFiles > 0 {
+		if err := setProcessRlimit(pid, syscall.RLIMIT_NOFILE, uint64(limits.MaxOpenFiles)); err != nil {
+			return err
+		}
+	}
+	// 重要逻辑：cgroup 可用时由 memory.max/pids.max 精确限制；
+	// 否则退回 RLIMIT_AS 与 RLIMIT_NPROC（后者按用户统计，只能作为兜底）。
+	if hasCgroup {
+		return nil
+	}
+	if limits.MaxMemory > 0 {
+		if err := setProcessRlimit(pid, syscall.RLIMIT_AS, uint64(limits.MaxMemory)); err != nil {
+			return err
+		}
+	}
+	if limits.MaxProcesses > 0 {
+		if err := setProcessRlimit(pid, rlimitNproc, uint64(limits.MaxProcesses)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rlimitNproc 是 RLIMIT_NPROC，syscall 包未导出该常量。
+const rlimitNproc = 6
+
+// setProcessRlimit 调用 prlimit64 设置指定进程的软硬限制。
+func setProcessRlimit(pid int, resource int, value uint64) error {
+	limit := syscall.Rlimit{Cur: value, Max: value}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&limit)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("set rlimit %d failed: %w", resource, errno)
+	}
+	return nil
+}
+
+// createSessionCgroup 为会话创建 cgroup v2 子组并移入进程，返回子组路径。
+func createSessionCgroup(root, sessionID string, pid int, limits ResourceLimits) (string, error) {
+	if root == "" {
+		return "", errors.New("cgroup disabled")
+	}
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		return "", errors.New("cgroup v2 not available")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return "", err
+	}
+	// 重要逻辑：父组需要开启子树控制器，子组的限制文件才会出现。
+	_ = os.WriteFile(filepath.Join(filepath.Dir(root), "cgroup.subtree_control"), []byte("+memory +cpu +pids"), 0o644)
+	if err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+memory +cpu +pids"), 0o644); err != nil {
+		return "", err
+	}
+	path := filepath.Join(root, sessionID)
+	if err := os.Mkdir(path, 0o755); err != nil {
+		return "", err
+	}
+	settings := map[string]string{}
+	if limits.MaxMemory > 0 {
+		settings["memory.max"] = strconv.FormatInt(limits.MaxMemory, 10)
+	}
+	if limits.CPUPercent > 0 {
+		// 重要逻辑：cpu.max 以 100ms 为周期，百分比可超过 100 表示多核。
+		settings["cpu.max"] = fmt.Sprintf("%d 100000", limits.CPUPercent*1000)
+	}
+	if limits.MaxProcesses > 0 {
+		settings["pids.max"] = strconv.Itoa(limits.MaxProcesses)
+	}
+	for name, value := range settings {
+		if err := os.WriteFile(filepath.Join(path, name), []byte(value), 0o644); err != nil {
+			_ = os.Remove(path)
+			return "", fmt.Errorf("write %s failed: %w", name, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(path, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0o644); err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// removeSessionCgroup 删除会话 cgroup 子组（需在进程全部退出后调用）。
+func removeSessionCgroup(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove cgroup %s failed: %w", path, err)
+	}
+	return nil
+}
+
+// killSessionCgroup 结束 cgroup 中的全部进程，内核不支持 cgroup.kill 时逐个发送 SIGKILL。
+func killSessionCgroup(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(path, "cgroup.kill"), []byte("1"), 0o644); err == nil {
+		return
+	}
+	for _, pid := range readCgroupProcs(path) {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
+
+// readCgroupProcs 返回 cgroup 中仍存在的进程，cgroup 不存在时为空。
+func readCgroupProcs(path string) []int {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// readCgroupUsage 从 cgroup 统计文件读取资源占用。
+func readCgroupUsage(path string) (*ResourceUsage, error) {
+	memory, err := readCgroupInt(filepath.Join(path, "memory.current"))
+	if err != nil {
+		return nil, err
+	}
+	usage := &ResourceUsage{MemoryBytes: memory, Source: "cgroup"}
+	if pids, err := readCgroupInt(filepath.Join(path, "pids.current")); err == nil {
+		usage.Processes = int(pids)
+	}
+	if data, err := os.ReadFile(filepath.Join(path, "cpu.stat")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "usage_usec" {
+				usec, _ := strconv.ParseInt(fields[1], 10, 64)
+				usage.CPUSeconds = float64(usec) / 1e6
+			}
+		}
+	}
+	return usage, nil
+}
+
+// readCgroupInt 读取 cgroup 中的单个整数值。
+func readCgroupInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readProcessTreeUsage 汇总进程树的资源占用，用于未启用 cgroup 的会话。
+func readProcessTreeUsage(pid int) (*ResourceUsage, error) {
+	tree, err := buildProcessTree(pid)
+	if err != nil {
+		return nil, err
+	}
+	usage := &ResourceUsage{Source: "proc"}
+	var walk func(node *ProcessNode)
+	walk = func(node *ProcessNode) {
+		usage.MemoryBytes += node.RSS
+		usage.CPUSeconds += node.CPUSeconds
+		usage.Processes++
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(tree)
+	return usage, nil
+}
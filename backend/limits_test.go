@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestMergeClampsToConfiguredCap 确认请求中更大的限制会被收紧到配置上限。
+func TestMergeClampsToConfiguredCap(t *testing.T) {
+	configured := ResourceLimits{MaxMemory: 512 << 20, CPUPercent: 50, MaxProcesses: 100}
+	merged := configured.Merge(ResourceLimits{MaxMemory: 4 << 30, CPUPercent: 400, MaxProcesses: 20, MaxOpenFiles: 256})
+	want := ResourceLimits{MaxMemory: 512 << 20, CPUPercent: 50, MaxProcesses: 20, MaxOpenFiles: 256}
+	if merged != want {
+		t.Fatalf("merged = %+v, want %+v", merged, want)
+	}
+}
+
+// TestCheckWithinRejectsRaisedLimit 确认超过配置上限的请求被拒绝，未配置的项可以自行设置。
+func TestCheckWithinRejectsRaisedLimit(t *testing.T) {
+	configured := ResourceLimits{MaxMemory: 512 << 20}
+	if err := configured.CheckWithin(ResourceLimits{MaxMemory: 1 << 30}); !errors.Is(err, errLimitExceedsCap) {
+		t.Fatalf("raised memory: err = %v, want errLimitExceedsCap", err)
+	}
+	if err := configured.CheckWithin(ResourceLimits{MaxMemory: 256 << 20, MaxProcesses: 64}); err != nil {
+		t.Fatalf("tightened limits: unexpected err %v", err)
+	}
+}
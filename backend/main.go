@@ -1,7 +1,9 @@
 package main
 
 import (
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
@@ -9,7 +11,42 @@ import (
 // main 启动 HTTP 服务并注册路由。
 func main() {
 	cfg := LoadConfig()
-	manager := NewSessionManager(cfg.Shell, cfg.BufferSize)
+	users, err := LoadUserStore(cfg.UsersFile)
+	if err != nil {
+		log.Fatalf("load users failed: %v", err)
+	}
+	auth := NewAuthenticator(cfg, users)
+	shares := NewShareManager()
+	origins := NewOriginPolicy(cfg.AllowedOrigins)
+	ipResolver, err := NewClientIPResolver(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("parse trusted proxies failed: %v", err)
+	}
+	allowedNets, err := parseCIDRList(cfg.AllowedCIDRs)
+	if err != nil {
+		log.Fatalf("parse allowed cidrs failed: %v", err)
+	}
+	loginLimiter := NewRateLimiter(cfg.LoginMaxFails, cfg.LoginWindow, cfg.LockoutPeriod)
+	sessionLimiter := NewRateLimiter(cfg.SessionRate, time.Minute, cfg.LockoutPeriod)
+	audit, err := NewAuditLogger(cfg.AuditLog, cfg.AuditInput)
+	if err != nil {
+		log.Fatalf("open audit log failed: %v", err)
+	}
+	manager := NewSessionManager(cfg, audit)
+	redactor, err := NewRedactor(cfg.Redact, cfg.RedactFile)
+	if err != nil {
+		log.Fatalf("load redact patterns failed: %v", err)
+	}
+
+	uploads, err := NewUploadManager(cfg.UploadDir, cfg.UploadTTL, cfg.UploadMaxSize)
+	if err != nil {
+		log.Fatalf("open upload staging dir failed: %v", err)
+	}
+	if err := uploads.PrepareAccounts(manager.runAsAccounts()); err != nil {
+		log.Fatalf("prepare upload staging dir failed: %v", err)
+	}
+	go manager.purgeExpiredTrash()
+	go uploads.purgeExpired()
 
 	logsHandler := HandleBackendLogs()
 
@@ -17,18 +54,54 @@ func main() {
 	mux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "ts": time.Now().Unix()})
 	})
-	mux.Handle("/api/session", HandleCreateSession(manager))
+	mux.Handle("/api/auth/login", HandleLogin(auth, loginLimiter))
+	mux.Handle("/api/auth/logout", HandleLogout())
+	mux.Handle("/api/auth/status", HandleAuthStatus(auth))
+	mux.Handle("/api/auth/csrf", HandleCSRFToken())
+	mux.Handle("/api/auth/totp/enroll", HandleTOTPEnroll(users))
+	mux.Handle("/api/auth/totp/verify", HandleTOTPVerify(users))
+	mux.Handle("/api/auth/totp/disable", HandleTOTPDisable(users))
+	mux.Handle("/api/users", HandleListUsers(users))
+	mux.Handle("/api/users/create", HandleCreateUser(users))
+	mux.Handle("/api/users/delete", HandleDeleteUser(users))
+	mux.Handle("/api/users/password", HandleChangePassword(users))
+	mux.Handle("/api/session", HandleCreateSession(manager, sessionLimiter))
 	mux.Handle("/api/session/close", HandleCloseSession(manager))
 	mux.Handle("/api/session/rename", HandleRenameSession(manager))
 	mux.Handle("/api/sessions", HandleListSessions(manager))
-	mux.Handle("/api/ws", WebSocketHandler(manager))
-	mux.Handle("/api/fs/tree", HandleFileTree(manager))
-	mux.Handle("/api/fs/upload", HandleFileUpload(manager))
-	mux.Handle("/api/fs/download", HandleFileDownload(manager))
-	mux.Handle("/api/fs/read", HandleFileRead(manager))
-	mux.Handle("/api/git/status", HandleGitStatus(manager))
-	mux.Handle("/api/git/diff", HandleGitDiff(manager))
+	mux.Handle("/api/session/processes", HandleSessionProcesses(manager))
+	mux.Handle("/api/session/export", HandleExportSession(manager, redactor))
+	mux.Handle("/api/session/search", HandleSearchSession(manager, redactor))
+	mux.Handle("/api/session/signal", HandleSignalSession(manager))
+	mux.Handle("/api/session/members", HandleSessionMembers(manager))
+	mux.Handle("/api/session/share", HandleCreateShare(manager, shares))
+	mux.Handle("/api/session/shares", HandleListShares(manager, shares))
+	mux.Handle("/api/session/share/revoke", HandleRevokeShare(manager, shares))
+	mux.Handle("/api/ws", WebSocketHandler(manager, shares, origins, redactor))
+	mux.Handle("/api/fs/roots", withSessionAccount(manager, HandleListRoots(manager)))
+	mux.Handle("/api/fs/tree", withSessionAccount(manager, HandleFileTree(manager)))
+	mux.Handle("/api/fs/find", withSessionAccount(manager, HandleFindFiles(manager)))
+	mux.Handle("/api/fs/upload", withSessionAccount(manager, HandleFileUpload(manager)))
+	mux.Handle("/api/fs/upload/init", withSessionAccount(manager, HandleUploadInit(manager, uploads)))
+	mux.Handle("/api/fs/upload/chunk", withSessionAccount(manager, HandleUploadChunk(uploads)))
+	mux.Handle("/api/fs/upload/status", HandleUploadStatus(uploads))
+	mux.Handle("/api/fs/upload/finalize", HandleUploadFinalize(manager, uploads))
+	mux.Handle("/api/fs/upload/cancel", HandleUploadCancel(uploads))
+	mux.Handle("/api/fs/download", withSessionAccount(manager, HandleFileDownload(manager)))
+	mux.Handle("/api/fs/read", withSessionAccount(manager, HandleFileRead(manager)))
+	mux.Handle("/api/fs/write", withSessionAccount(manager, HandleFileWrite(manager)))
+	mux.Handle("/api/fs/mkdir", withSessionAccount(manager, HandleMakeDir(manager)))
+	mux.Handle("/api/fs/create", withSessionAccount(manager, HandleCreateFile(manager)))
+	mux.Handle("/api/fs/move", withSessionAccount(manager, HandleMoveFile(manager)))
+	mux.Handle("/api/fs/copy", withSessionAccount(manager, HandleCopyFile(manager)))
+	mux.Handle("/api/fs/delete", withSessionAccount(manager, HandleDeleteFile(manager)))
+	mux.Handle("/api/fs/trash", withSessionAccount(manager, HandleListTrash(manager)))
+	mux.Handle("/api/fs/trash/restore", withSessionAccount(manager, HandleRestoreTrash(manager)))
+	mux.Handle("/api/fs/trash/purge", withSessionAccount(manager, HandlePurgeTrash(manager)))
+	mux.Handle("/api/git/status", withSessionAccount(manager, HandleGitStatus(manager)))
+	mux.Handle("/api/git/diff", withSessionAccount(manager, HandleGitDiff(manager)))
 	mux.Handle("/api/logs/backend", logsHandler)
+	mux.Handle("/api/audit", HandleAuditQuery(audit))
 
 	if cfg.StaticDir != "" {
 		fileServer := http.FileServer(http.Dir(cfg.StaticDir))
@@ -44,28 +117,49 @@ func main() {
 		mux.ServeHTTP(w, r)
 	})
 
+	addr := net.JoinHostPort(cfg.BindAddr, cfg.Port)
 	server := &http.Server{
-		Addr:              ":" + cfg.Port,
-		Handler:           withCORS(handler),
+		Addr:              addr,
+		Handler:           withIPFilter(ipResolver, allowedNets, withCORS(origins, withAuth(auth, withAudit(audit, withCSRF(handler))))),
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
-	log.Printf("server listening on :%s", cfg.Port)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("server failed: %v", err)
+	if !auth.Enabled() {
+		log.Printf("warning: authentication disabled, set APP_AUTH_PASSWORD, APP_AUTH_TOKEN or APP_USERS_FILE to enable")
 	}
-}
 
-// withCORS 为开发环境提供简单的跨域支持。
-func withCORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
+	if !cfg.TLSEnabled {
+		log.Printf("server listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("server failed: %v", err)
 		}
-		next.ServeHTTP(w, r)
-	})
+		return
+	}
+
+	cert, err := loadTLSCertificate(cfg)
+	if err != nil {
+		log.Fatalf("load tls certificate failed: %v", err)
+	}
+	server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.HTTPRedirect != "" {
+		redirectAddr := net.JoinHostPort(cfg.BindAddr, cfg.HTTPRedirect)
+		go func() {
+			redirect := &http.Server{
+				Addr:              redirectAddr,
+				Handler:           redirectToHTTPS(cfg.Port),
+				ReadHeaderTimeout: 5 * time.Second,
+			}
+			log.Printf("http redirect listening on %s", redirectAddr)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("http redirect failed: %v", err)
+			}
+		}()
+	}
+	log.Printf("server listening on %s (tls)", addr)
+	if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("server failed: %v", err)
+	}
 }
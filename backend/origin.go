@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	csrfCookieName = "anywhere_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// OriginPolicy 判断浏览器请求的来源是否可信，CORS 与 WebSocket 握手共用。
+type OriginPolicy struct {
+	allowAll bool
+	origins  map[string]bool
+}
+
+// NewOriginPolicy 根据配置的来源列表创建 OriginPolicy。
+func NewOriginPolicy(origins []string) *OriginPolicy {
+	policy := &OriginPolicy{origins: make(map[string]bool, len(origins))}
+	for _, origin := range origins {
+		if origin == "*" {
+			policy.allowAll = true
+			continue
+		}
+		policy.origins[strings.TrimRight(strings.ToLower(origin), "/")] = true
+	}
+	return policy
+}
+
+// Allowed 判断请求来源是否允许；没有 Origin 头的非浏览器请求直接放行。
+func (p *OriginPolicy) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if p.allowAll {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	if len(p.origins) > 0 {
+		return p.origins[strings.ToLower(parsed.Scheme+"://"+parsed.Host)]
+	}
+	// 重要逻辑：未配置来源列表时只信任与服务同主机名的页面（端口可不同，兼容前端开发服务器）。
+	return strings.EqualFold(hostWithoutPort(parsed.Host), hostWithoutPort(r.Host))
+}
+
+// hostWithoutPort 去掉 host:port 中的端口部分。
+func hostWithoutPort(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+	return strings.Trim(host, "[]")
+}
+
+// withCORS 按来源白名单返回跨域响应头。
+func withCORS(policy *OriginPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && !policy.Allowed(r) {
+			writeError(w, http.StatusForbidden, "origin not allowed")
+			return
+		}
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Add("Vary", "Origin")
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+csrfHeaderName)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withCSRF 对依赖 Cookie 认证的写操作校验双重提交的 CSRF 令牌。
+func withCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isStateChangingMethod(r.Method) || r.URL.Path == "/api/auth/login" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// 重要逻辑：Bearer 令牌不会被浏览器自动携带，只有 Cookie 认证需要防 CSRF。
+		if r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, err := r.Cookie(authCookieName); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cookie, err := r.Cookie(csrfCookieName)
+		header := r.Header.Get(csrfHeaderName)
+		if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			writeError(w, http.StatusForbidden, "csrf token invalid")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isStateChangingMethod 判断请求方法是否会修改状态。
+func isStateChangingMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// issueCSRFCookie 生成 CSRF 令牌并写入可被前端读取的 Cookie。
+func issueCSRFCookie(w http.ResponseWriter, r *http.Request, expiresAt time.Time) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+// HandleCSRFToken 返回当前 CSRF 令牌，不存在时重新生成。
+func HandleCSRFToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+			writeJSON(w, http.StatusOK, map[string]any{"csrf_token": cookie.Value})
+			return
+		}
+		token, err := issueCSRFCookie(w, r, time.Time{})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "generate csrf token failed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"csrf_token": token})
+	}
+}
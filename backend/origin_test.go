@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCSRFRequiresHeaderForCookieAuth 确认 Cookie 认证的写请求缺少或伪造 CSRF 头时被拒绝，回传 Cookie 中的令牌时放行。
+func TestCSRFRequiresHeaderForCookieAuth(t *testing.T) {
+	handler := withCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	cases := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "missing header", header: "", want: http.StatusForbidden},
+		{name: "wrong header", header: "other", want: http.StatusForbidden},
+		{name: "matching header", header: "csrf-value", want: http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/session", nil)
+		req.AddCookie(&http.Cookie{Name: authCookieName, Value: "session-token"})
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "csrf-value"})
+		if tc.header != "" {
+			req.Header.Set(csrfHeaderName, tc.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("%s: status = %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxOSC7Length 限制单条 OSC 7 序列的最大长度，避免异常输出导致缓存无限增长。
+const maxOSC7Length = 4096
+
+var osc7Prefix = []byte("\x1b]7;")
+
+// OSC7Parser 从 PTY 输出流中解析 OSC 7 工作目录上报。
+type OSC7Parser struct {
+	pending []byte
+}
+
+// Feed 解析一段输出，返回其中最后一次上报的本机目录。
+func (p *OSC7Parser) Feed(data []byte) (string, bool) {
+	buf := data
+	if len(p.pending) > 0 {
+		buf = append(p.pending, data...)
+		p.pending = nil
+	}
+
+	cwd := ""
+	found := false
+	for {
+		start := bytes.Index(buf, osc7Prefix)
+		if start < 0 {
+			// 重要逻辑：保留可能被切断的序列前缀，等待下一段输出拼接。
+			p.pending = trailingPrefix(buf, osc7Prefix)
+			break
+		}
+		rest := buf[start+len(osc7Prefix):]
+		end, termLen := findOSCTerminator(rest)
+		if end < 0 {
+			if len(rest) <= maxOSC7Length {
+				p.pending = append([]byte(nil), buf[start:]...)
+			}
+			break
+		}
+		if path, ok := parseOSC7URI(string(rest[:end])); ok {
+			cwd = path
+			found = true
+		}
+		buf = rest[end+termLen:]
+	}
+	return cwd, found
+}
+
+// findOSCTerminator 查找 OSC 序列的结束符（BEL 或 ST），返回位置与结束符长度。
+func findOSCTerminator(data []byte) (int, int) {
+	for i, b := range data {
+		if b == '\a' {
+			return i, 1
+		}
+		if b == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+			return i, 2
+		}
+		if b == 0x1b && i+1 == len(data) {
+			return -1, 0
+		}
+	}
+	return -1, 0
+}
+
+// trailingPrefix 返回 data 末尾与 prefix 开头重合的部分。
+func trailingPrefix(data, prefix []byte) []byte {
+	for n := len(prefix) - 1; n > 0; n-- {
+		if len(data) >= n && bytes.Equal(data[len(data)-n:], prefix[:n]) {
+			return append([]byte(nil), data[len(data)-n:]...)
+		}
+	}
+	return nil
+}
+
+// parseOSC7URI 解析 file://host/path 形式的目录上报，仅接受本机路径。
+func parseOSC7URI(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "file" || u.Path == "" {
+		return "", false
+	}
+	// 重要逻辑：ssh 到远端后上报的是远端主机路径，不能用于本机文件面板。
+	if u.Host != "" && u.Host != "localhost" {
+		hostname, err := os.Hostname()
+		if err != nil || u.Host != hostname {
+			return "", false
+		}
+	}
+	return u.Path, true
+}
+
+// maxOSCMarkerLength 限制单条命令标记序列（含 633;E 上报的命令行）的最大长度。
+const maxOSCMarkerLength = 8192
+
+var oscPrefix = []byte("\x1b]")
+
+// CommandMarkerParser 从 PTY 输出流中解析 OSC 133/633 shell 集成标记，还原用户执行的命令。
+// 133/633 的 A 为提示符开始、B 为命令输入开始、C 为命令开始执行、D 为命令结束；
+// 633;E 由 shell 直接上报命令行，没有 E 时以 B 与 C 之间回显的文本作为命令。
+type CommandMarkerParser struct {
+	pending     []byte
+	atPrompt    bool
+	echo        []byte
+	explicit    string
+	hasExplicit bool
+}
+
+// Feed 解析一段输出，返回其中开始执行的命令。
+func (p *CommandMarkerParser) Feed(data []byte) []string {
+	buf := data
+	if len(p.pending) > 0 {
+		buf = append(p.pending, data...)
+		p.pending = nil
+	}
+
+	var commands []string
+	for len(buf) > 0 {
+		start := bytes.Index(buf, oscPrefix)
+		if start < 0 {
+			// 重要逻辑：保留可能被切断的序列前缀，等待下一段输出拼接。
+			tail := trailingPrefix(buf, oscPrefix)
+			p.capture(buf[:len(buf)-len(tail)])
+			p.pending = tail
+			break
+		}
+		p.capture(buf[:start])
+		rest := buf[start+len(oscPrefix):]
+		end, termLen := findOSCTerminator(rest)
+		if end < 0 {
+			if len(rest) <= maxOSCMarkerLength {
+				p.pending = append([]byte(nil), buf[start:]...)
+			}
+			break
+		}
+		if command, ok := p.handle(string(rest[:end])); ok {
+			commands = append(commands, command)
+		}
+		buf = rest[end+termLen:]
+	}
+	return commands
+}
+
+// AtPrompt 判断 shell 是否正在等待用户输入命令行（B 标记之后、C 标记之前）。
+func (p *CommandMarkerParser) AtPrompt() bool {
+	return p.atPrompt
+}
+
+// capture 在命令输入阶段累积回显文本。
+func (p *CommandMarkerParser) capture(data []byte) {
+	if !p.atPrompt || len(p.echo) >= maxAuditInputLine {
+		return
+	}
+	if room := maxAuditInputLine - len(p.echo); len(data) > room {
+		data = data[:room]
+	}
+	p.echo = append(p.echo, data...)
+}
+
+// handle 处理一条 OSC 序列，C 标记时返回本次执行的命令。
+func (p *CommandMarkerParser) handle(payload string) (string, bool) {
+	if !strings.HasPrefix(payload, "133;") && !strings.HasPrefix(payload, "633;") {
+		return "", false
+	}
+	fields := strings.SplitN(payload[4:], ";", 3)
+	switch fields[0] {
+	case "A", "D":
+		p.reset()
+	case "B":
+		p.reset()
+		p.atPrompt = true
+	case "E":
+		if len(fields) > 1 {
+			p.explicit = unescapeOSC633(fields[1])
+			p.hasExplicit = true
+		}
+	case "C":
+		command := p.explicit
+		if !p.hasExplicit {
+			command = cleanEchoedCommand(p.echo)
+		}
+		p.reset()
+		command = strings.TrimSpace(command)
+		return command, command != ""
+	}
+	return "", false
+}
+
+// reset 清除当前命令行的累积状态。
+func (p *CommandMarkerParser) reset() {
+	p.atPrompt = false
+	p.echo = nil
+	p.explicit = ""
+	p.hasExplicit = false
+}
+
+// cleanEchoedCommand 去除回显中的控制序列并应用退格，得到最终的命令行文本。
+func cleanEchoedCommand(echo []byte) string {
+	text := []rune(stripANSI(echo))
+	line := make([]rune, 0, len(text))
+	for _, r := range text {
+		switch r {
+		case '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case '\n':
+			line = append(line, ' ')
+		default:
+			if r >= 0x20 && r != 0x7f {
+				line = append(line, r)
+			}
+		}
+	}
+	return string(line)
+}
+
+// unescapeOSC633 还原 633;E 中以 \\ 与 \xHH 转义的命令行。
+func unescapeOSC633(raw string) string {
+	var out strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '\\' && i+1 < len(raw) {
+			if raw[i+1] == '\\' {
+				out.WriteByte('\\')
+				i++
+				continue
+			}
+			if raw[i+1] == 'x' && i+3 < len(raw) {
+				if value, err := strconv.ParseUint(raw[i+2:i+4], 16, 8); err == nil {
+					out.WriteByte(byte(value))
+					i += 3
+					continue
+				}
+			}
+		}
+		out.WriteByte(raw[i])
+	}
+	return out.String()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestCommandMarkerParserEcho 确认没有 633;E 时以 B 与 C 之间的回显还原命令，并处理退格与被切断的序列。
+func TestCommandMarkerParserEcho(t *testing.T) {
+	var parser CommandMarkerParser
+	var commands []string
+	for _, chunk := range []string{
+		"\x1b]133;A\x07$ \x1b]13",
+		"3;B\x07",
+		"lx\b \bs -la\x1b[K",
+		"\r\n\x1b]133;C\x07total 0\r\n\x1b]133;D;0\x07",
+	} {
+		if chunk == "lx\b \bs -la\x1b[K" && !parser.AtPrompt() {
+			t.Fatalf("not at prompt after B marker")
+		}
+		commands = append(commands, parser.Feed([]byte(chunk))...)
+	}
+	if want := []string{"ls -la"}; !reflect.DeepEqual(commands, want) {
+		t.Fatalf("commands = %q, want %q", commands, want)
+	}
+	if parser.AtPrompt() {
+		t.Fatalf("still at prompt after C marker")
+	}
+}
+
+// TestCommandMarkerParserExplicit 确认优先使用 633;E 上报并转义的命令行。
+func TestCommandMarkerParserExplicit(t *testing.T) {
+	var parser CommandMarkerParser
+	commands := parser.Feed([]byte("\x1b]633;B\x07echo a\x1b]633;E;echo a\\x3bb\\\\c;nonce\x07\x1b]633;C\x07"))
+	if want := []string{"echo a;b\\c"}; !reflect.DeepEqual(commands, want) {
+		t.Fatalf("commands = %q, want %q", commands, want)
+	}
+}
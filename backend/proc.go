@@ -0,0 +1,414 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// foregroundPGID 通过 PTY 获取当前前台进程组 ID。
+func foregroundPGID(ptmx *os.File) (int, error) {
+	if ptmx == nil {
+		return 0, errors.New("pty closed")
+	}
+	var pgid int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&pgid)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pgid), nil
+}
+
+// terminalInputHidden 读取 PTY 的 termios，判断当前输入是否不应明文记录。
+// 规范模式下关闭回显是 getpass、sudo、read -s 读取密码的方式；原始模式下关闭回显时，
+// 前台进程组是 shell 说明是 readline 在编辑命令行，否则（vim、ssh 等）无法确定内容，按隐藏处理。
+// 读取失败时按隐藏处理。
+func terminalInputHidden(ptmx *os.File, shellPID int) bool {
+	if ptmx == nil {
+		return true
+	}
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), uintptr(syscall.TCGETS), uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return true
+	}
+	if termios.Lflag&syscall.ECHO != 0 {
+		return false
+	}
+	if termios.Lflag&syscall.ICANON != 0 {
+		return true
+	}
+	foreground, err := foregroundPGID(ptmx)
+	return err != nil || foreground != shellPID
+}
+
+// readProcCWD 通过 /proc 读取进程当前目录。
+func readProcCWD(pid int) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+}
+
+// clockTicksPerSecond 是 /proc 中 CPU 时间的单位（Linux USER_HZ 固定为 100）。
+const clockTicksPerSecond = 100
+
+// ProcessNode 描述会话进程树中的一个进程。
+type ProcessNode struct {
+	PID        int            `json:"pid"`
+	PPID       int            `json:"ppid"`
+	PGID       int            `json:"pgid"`
+	Command    string         `json:"command"`
+	Cmdline    string         `json:"cmdline"`
+	State      string         `json:"state"`
+	CPUSeconds float64        `json:"cpu_seconds"`
+	CPUPercent float64        `json:"cpu_percent"`
+	RSS        int64          `json:"rss"`
+	Children   []*ProcessNode `json:"children"`
+}
+
+// ForegroundProcess 描述会话 PTY 的前台进程。
+type ForegroundProcess struct {
+	PID     int    `json:"pid"`
+	PGID    int    `json:"pgid"`
+	Command string `json:"command"`
+	Cmdline string `json:"cmdline"`
+}
+
+// procStat 是 /proc/<pid>/stat 中需要的字段。
+type procStat struct {
+	pid       int
+	comm      string
+	state     string
+	ppid      int
+	pgrp      int
+	session   int
+	utime     uint64
+	stime     uint64
+	starttime uint64
+	rssPages  int64
+}
+
+// readProcStat 解析 /proc/<pid>/stat。
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, err
+	}
+	// 重要逻辑：comm 可能包含空格和括号，以最后一个右括号为界切分。
+	text := string(data)
+	open := strings.IndexByte(text, '(')
+	closeIdx := strings.LastIndexByte(text, ')')
+	if open < 0 || closeIdx < open {
+		return procStat{}, errors.New("invalid stat")
+	}
+	fields := strings.Fields(text[closeIdx+1:])
+	// fields[0] 对应 stat 的第 3 个字段 state。
+	if len(fields) < 22 {
+		return procStat{}, errors.New("invalid stat")
+	}
+	stat := procStat{
+		pid:   pid,
+		comm:  text[open+1 : closeIdx],
+		state: fields[0],
+	}
+	stat.ppid, _ = strconv.Atoi(fields[1])
+	stat.pgrp, _ = strconv.Atoi(fields[2])
+	stat.session, _ = strconv.Atoi(fields[3])
+	stat.utime, _ = strconv.ParseUint(fields[11], 10, 64)
+	stat.stime, _ = strconv.ParseUint(fields[12], 10, 64)
+	stat.starttime, _ = strconv.ParseUint(fields[19], 10, 64)
+	stat.rssPages, _ = strconv.ParseInt(fields[21], 10, 64)
+	return stat, nil
+}
+
+// readProcCmdline 读取进程完整命令行。
+func readProcCmdline(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(data), "\x00", " "))
+}
+
+// listProcStats 读取系统中所有进程的 stat。
+func listProcStats() ([]procStat, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]procStat, 0, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// 重要逻辑：进程可能在遍历期间退出，读取失败直接跳过。
+		stat, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// readSystemUptime 读取系统启动以来的秒数。
+func readSystemUptime() float64 {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+	uptime, _ := strconv.ParseFloat(fields[0], 64)
+	return uptime
+}
+
+// buildProcessTree 构造以 rootPID 为根的进程树。
+func buildProcessTree(rootPID int) (*ProcessNode, error) {
+	stats, err := listProcStats()
+	if err != nil {
+		return nil, err
+	}
+	uptime := readSystemUptime()
+	pageSize := int64(os.Getpagesize())
+	nodes := make(map[int]*ProcessNode, len(stats))
+	children := make(map[int][]int)
+	for _, stat := range stats {
+		cpuSeconds := float64(stat.utime+stat.stime) / clockTicksPerSecond
+		cpuPercent := 0.0
+		// 重要逻辑：CPU 占用按进程生命周期平均计算，无需两次采样。
+		if elapsed := uptime - float64(stat.starttime)/clockTicksPerSecond; elapsed > 0 {
+			cpuPercent = cpuSeconds / elapsed * 100
+		}
+		nodes[stat.pid] = &ProcessNode{
+			PID:        stat.pid,
+			PPID:       stat.ppid,
+			PGID:       stat.pgrp,
+			Command:    stat.comm,
+			State:      stat.state,
+			CPUSeconds: cpuSeconds,
+			CPUPercent: cpuPercent,
+			RSS:        stat.rssPages * pageSize,
+			Children:   []*ProcessNode{},
+		}
+		children[stat.ppid] = append(children[stat.ppid], stat.pid)
+	}
+	root, ok := nodes[rootPID]
+	if !ok {
+		return nil, errors.New("process not found")
+	}
+	queue := []*ProcessNode{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		node.Cmdline = readProcCmdline(node.PID)
+		childPIDs := children[node.PID]
+		sort.Ints(childPIDs)
+		for _, childPID := range childPIDs {
+			child := nodes[childPID]
+			node.Children = append(node.Children, child)
+			queue = append(queue, child)
+		}
+	}
+	return root, nil
+}
+
+// resolveForegroundProcess 返回 PTY 前台进程组的组长进程。
+func resolveForegroundProcess(ptmx *os.File) (*ForegroundProcess, error) {
+	pgid, err := foregroundPGID(ptmx)
+	if err != nil {
+		return nil, err
+	}
+	if pgid <= 0 {
+		return nil, errors.New("no foreground process")
+	}
+	stat, err := readProcStat(pgid)
+	if err != nil {
+		return nil, err
+	}
+	return &ForegroundProcess{
+		PID:     stat.pid,
+		PGID:    pgid,
+		Command: stat.comm,
+		Cmdline: readProcCmdline(pgid),
+	}, nil
+}
+
+// readProcComm 读取进程名称。
+func readProcComm(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// closeGracePeriod 是关闭会话时每一级信号的等待时间。
+const closeGracePeriod = 2 * time.Second
+
+// closeKillTimeout 是发送 SIGKILL 后等待会话进程全部退出的最长时间。
+const closeKillTimeout = 10 * time.Second
+
+// closePollInterval 是关闭会话时检查剩余进程的间隔。
+const closePollInterval = 100 * time.Millisecond
+
+// sessionSignals 是允许通过 API 发送的信号。
+var sessionSignals = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGTSTP": syscall.SIGTSTP,
+	"SIGCONT": syscall.SIGCONT,
+}
+
+// parseSessionSignal 解析信号名称，兼容省略 SIG 前缀与小写。
+func parseSessionSignal(name string) (syscall.Signal, bool) {
+	key := strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(key, "SIG") {
+		key = "SIG" + key
+	}
+	sig, ok := sessionSignals[key]
+	return sig, ok
+}
+
+// processTreeContains 判断进程树中是否包含指定 PID。
+func processTreeContains(root *ProcessNode, pid int) bool {
+	if root == nil {
+		return false
+	}
+	if root.PID == pid {
+		return true
+	}
+	for _, child := range root.Children {
+		if processTreeContains(child, pid) {
+			return true
+		}
+	}
+	return false
+}
+
+// terminateSessionProcess 按 SIGHUP → SIGTERM → SIGKILL 逐级结束会话的全部进程，
+// 直到进程树与 cgroup 都为空后删除 cgroup，仍有进程残留或删除失败时返回错误。
+func terminateSessionProcess(cmd *exec.Cmd, foreground int, cgroupPath string) error {
+	pid := cmd.Process.Pid
+	// 重要逻辑：发送信号前先记录进程树，shell 退出后后台进程会被重新挂到 init 下，无法再从 shell 找到。
+	tracker := newSessionProcessTracker(pid)
+	tracker.refresh()
+	done := make(chan struct{})
+	go func() {
+		// 重要逻辑：回收子进程，避免关闭后残留僵尸进程。
+		_ = cmd.Wait()
+		close(done)
+	}()
+	exited := func() bool {
+		select {
+		case <-done:
+		default:
+			return false
+		}
+		return len(tracker.refresh()) == 0 && len(readCgroupProcs(cgroupPath)) == 0
+	}
+	for _, sig := range []syscall.Signal{syscall.SIGHUP, syscall.SIGTERM} {
+		signalProcessGroups(pid, foreground, sig)
+		tracker.signal(sig)
+		for deadline := time.Now().Add(closeGracePeriod); time.Now().Before(deadline); time.Sleep(closePollInterval) {
+			if exited() {
+				return removeSessionCgroup(cgroupPath)
+			}
+		}
+	}
+	for deadline := time.Now().Add(closeKillTimeout); !exited(); time.Sleep(closePollInterval) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("session processes still running after SIGKILL: %v", append(tracker.refresh(), readCgroupProcs(cgroupPath)...))
+		}
+		// 重要逻辑：持续发送 SIGKILL，覆盖结束过程中新 fork 出的进程。
+		signalProcessGroups(pid, foreground, syscall.SIGKILL)
+		tracker.signal(syscall.SIGKILL)
+		killSessionCgroup(cgroupPath)
+	}
+	return removeSessionCgroup(cgroupPath)
+}
+
+// sessionProcessTracker 跟踪会话的全部进程，包括 shell 退出后被重新挂到 init 下的后台进程。
+type sessionProcessTracker struct {
+	sid   int
+	known map[int]uint64
+}
+
+// newSessionProcessTracker 创建以 shell 为根的进程跟踪器，shell 以 setsid 启动，其 PID 即会话 ID。
+func newSessionProcessTracker(pid int) *sessionProcessTracker {
+	return &sessionProcessTracker{sid: pid, known: map[int]uint64{}}
+}
+
+// refresh 重新扫描进程，返回仍存活的会话进程：属于同一会话、已跟踪或其后代的进程。
+func (t *sessionProcessTracker) refresh() []int {
+	stats, err := listProcStats()
+	if err != nil {
+		return nil
+	}
+	children := make(map[int][]procStat)
+	var queue []procStat
+	for _, stat := range stats {
+		// 重要逻辑：僵尸进程无法再被信号结束，由其父进程或 init 回收。
+		if stat.state == "Z" {
+			continue
+		}
+		children[stat.ppid] = append(children[stat.ppid], stat)
+		// 重要逻辑：已跟踪的 PID 需核对启动时间，避免误杀复用了该 PID 的其他进程。
+		if started, ok := t.known[stat.pid]; (ok && started == stat.starttime) || stat.session == t.sid {
+			queue = append(queue, stat)
+		}
+	}
+	alive := make(map[int]uint64, len(queue))
+	for len(queue) > 0 {
+		stat := queue[0]
+		queue = queue[1:]
+		if _, ok := alive[stat.pid]; ok {
+			continue
+		}
+		alive[stat.pid] = stat.starttime
+		queue = append(queue, children[stat.pid]...)
+	}
+	t.known = alive
+	pids := make([]int, 0, len(alive))
+	for pid := range alive {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// signal 向已跟踪的全部进程发送信号。
+func (t *sessionProcessTracker) signal(sig syscall.Signal) {
+	for pid := range t.known {
+		_ = syscall.Kill(pid, sig)
+	}
+}
+
+// killSessionProcess 立即结束尚未交给用户的会话进程，并在后台回收后执行清理，不阻塞调用方。
+func killSessionProcess(cmd *exec.Cmd, cleanup func()) {
+	signalProcessGroups(cmd.Process.Pid, 0, syscall.SIGKILL)
+	go func() {
+		_ = cmd.Wait()
+		cleanup()
+	}()
+}
+
+// signalProcessGroups 向 shell 所在进程组及前台进程组发送信号。
+func signalProcessGroups(pid, foreground int, sig syscall.Signal) {
+	// 重要逻辑：shell 由 pty.Start 以 setsid 启动，其 PID 即进程组 ID。
+	if err := syscall.Kill(-pid, sig); err != nil {
+		_ = syscall.Kill(pid, sig)
+	}
+	if foreground > 0 && foreground != pid {
+		_ = syscall.Kill(-foreground, sig)
+	}
+}
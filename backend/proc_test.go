@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/creack/pty"
+)
+
+// TestTerminalInputHidden 确认只有读取密码（规范模式关闭回显）与非 shell 前台的原始模式输入视为隐藏，
+// shell 的 readline 原始模式输入正常记录。
+func TestTerminalInputHidden(t *testing.T) {
+	cmd := exec.Command("sleep", "30")
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		t.Skipf("pty unavailable: %v", err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = ptmx.Close()
+	}()
+	shell := cmd.Process.Pid
+	cases := []struct {
+		name   string
+		lflag  uint32
+		shell  int
+		hidden bool
+	}{
+		{"canonical echo", syscall.ICANON | syscall.ECHO, shell, false},
+		{"getpass", syscall.ICANON, shell, true},
+		{"readline at shell prompt", 0, shell, false},
+		{"raw mode in foreground program", 0, shell + 1, true},
+		{"raw mode with echo", syscall.ECHO, shell + 1, false},
+	}
+	for _, tc := range cases {
+		var termios syscall.Termios
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), uintptr(syscall.TCGETS), uintptr(unsafe.Pointer(&termios))); errno != 0 {
+			t.Fatalf("TCGETS: %v", errno)
+		}
+		termios.Lflag = termios.Lflag&^(syscall.ICANON|syscall.ECHO) | tc.lflag
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), uintptr(syscall.TCSETS), uintptr(unsafe.Pointer(&termios))); errno != 0 {
+			t.Fatalf("TCSETS: %v", errno)
+		}
+		if got := terminalInputHidden(ptmx, tc.shell); got != tc.hidden {
+			t.Fatalf("%s: hidden = %v, want %v", tc.name, got, tc.hidden)
+		}
+	}
+}
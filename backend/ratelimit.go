@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter 按键（客户端 IP）统计窗口内事件次数，超限后锁定一段时间。
+type RateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	lockout   time.Duration
+	entries   map[string]*rateEntry
+	lastPrune time.Time
+}
+
+// rateEntry 是单个键的计数状态。
+type rateEntry struct {
+	count       int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// NewRateLimiter 创建 RateLimiter，limit 不大于 0 时不做限制。
+func NewRateLimiter(limit int, window, lockout time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		lockout: lockout,
+		entries: make(map[string]*rateEntry),
+	}
+}
+
+// Locked 判断键是否处于锁定期，返回剩余时间。
+func (l *RateLimiter) Locked(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[key]
+	if !ok {
+		return 0, false
+	}
+	remaining := time.Until(entry.lockedUntil)
+	return remaining, remaining > 0
+}
+
+// Record 记录一次事件，窗口内达到上限时进入锁定并返回 true。
+func (l *RateLimiter) Record(key string) bool {
+	if l.limit <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	entry := l.entryLocked(key, now)
+	if now.Before(entry.lockedUntil) {
+		return true
+	}
+	return l.countLocked(entry, now)
+}
+
+// Reserve 在同一把锁内检查锁定并计入一次事件，锁定期内返回剩余时间与 false。
+// 重要逻辑：处理请求前先计数，并发请求无法在第一次失败被记录前同时通过检查；
+// 达到上限的那次仍然放行，之后的请求在锁定期内被拒绝。
+func (l *RateLimiter) Reserve(key string) (time.Duration, bool) {
+	if l.limit <= 0 {
+		return 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	entry := l.entryLocked(key, now)
+	if now.Before(entry.lockedUntil) {
+		return entry.lockedUntil.Sub(now), false
+	}
+	l.countLocked(entry, now)
+	return 0, true
+}
+
+// entryLocked 返回键的计数状态，不存在时创建（需要在锁内调用）。
+func (l *RateLimiter) entryLocked(key string, now time.Time) *rateEntry {
+	l.pruneLocked(now)
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &rateEntry{windowStart: now}
+		l.entries[key] = entry
+	}
+	return entry
+}
+
+// countLocked 计入一次事件，窗口内达到上限时进入锁定并返回 true（需要在锁内调用）。
+func (l *RateLimiter) countLocked(entry *rateEntry, now time.Time) bool {
+	if now.Sub(entry.windowStart) > l.window {
+		entry.count = 0
+		entry.windowStart = now
+	}
+	entry.count++
+	// 重要逻辑：第 limit 次事件即触发锁定，limit 即窗口内允许的最多次数。
+	if entry.count >= l.limit {
+		entry.lockedUntil = now.Add(l.lockout)
+		entry.count = 0
+		entry.windowStart = now
+		return true
+	}
+	return false
+}
+
+// Reset 清除键的计数（例如登录成功后）。
+func (l *RateLimiter) Reset(key string) {
+	l.mu.Lock()
+	delete(l.entries, key)
+	l.mu.Unlock()
+}
+
+// pruneLocked 定期清理过期条目，避免大量不同 IP 占用内存（需要在锁内调用）。
+func (l *RateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for key, entry := range l.entries {
+		if now.Sub(entry.windowStart) > l.window && now.After(entry.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// writeRateLimited 返回 429 并提示客户端重试时间。
+func writeRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, http.StatusTooManyRequests, "too many requests")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRateLimiterLocksAtLimit 确认第 limit 次事件即进入锁定，之前的事件不受影响。
+func TestRateLimiterLocksAtLimit(t *testing.T) {
+	limiter := NewRateLimiter(3, time.Minute, time.Minute)
+	for i := 1; i < 3; i++ {
+		if limiter.Record("10.0.0.1") {
+			t.Fatalf("attempt %d locked, want allowed", i)
+		}
+		if _, locked := limiter.Locked("10.0.0.1"); locked {
+			t.Fatalf("locked after attempt %d", i)
+		}
+	}
+	if !limiter.Record("10.0.0.1") {
+		t.Fatalf("attempt 3 not locked")
+	}
+	if _, locked := limiter.Locked("10.0.0.1"); !locked {
+		t.Fatalf("not locked after reaching limit")
+	}
+	if _, locked := limiter.Locked("10.0.0.2"); locked {
+		t.Fatalf("other key locked")
+	}
+}
+
+// TestRateLimiterReserveConcurrent 确认并发请求在锁定前最多放行 limit 次。
+func TestRateLimiterReserveConcurrent(t *testing.T) {
+	limiter := NewRateLimiter(3, time.Minute, time.Minute)
+	var wg sync.WaitGroup
+	var allowed int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := limiter.Reserve("10.0.0.1"); ok {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Fatalf("allowed = %d, want 3", allowed)
+	}
+	if _, ok := limiter.Reserve("10.0.0.1"); ok {
+		t.Fatalf("reserve allowed during lockout")
+	}
+	limiter.Reset("10.0.0.1")
+	if _, ok := limiter.Reserve("10.0.0.1"); !ok {
+		t.Fatalf("reserve denied after reset")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const redactedPlaceholder = "[REDACTED]"
+
+// builtinRedactPatterns 是常见密钥格式；含捕获组时只替换第一个捕获组。
+var builtinRedactPatterns = []string{
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	`\bgithub_pat_[A-Za-z0-9_]{22,}\b`,
+	`\bglpat-[A-Za-z0-9_-]{20,}\b`,
+	`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`,
+	`\bsk-[A-Za-z0-9_-]{20,}\b`,
+	`\bAIza[0-9A-Za-z_-]{35}\b`,
+	`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\b`,
+	`(?i)\b(?:password|passwd|secret|token|api[_-]?key|access[_-]?key)["']?\s*[=:]\s*["']?([^\s"']{6,})`,
+}
+
+// Redactor 在回放、导出与搜索终端输出时替换敏感信息。
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor 创建脱敏过滤器，patternsFile 中每行一个正则（# 开头为注释）。
+func NewRedactor(builtin bool, patternsFile string) (*Redactor, error) {
+	sources := make([]string, 0)
+	if builtin {
+		sources = append(sources, builtinRedactPatterns...)
+	}
+	if patternsFile != "" {
+		file, err := os.Open(patternsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			sources = append(sources, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	redactor := &Redactor{patterns: make([]*regexp.Regexp, 0, len(sources))}
+	for _, source := range sources {
+		pattern, err := regexp.Compile(source)
+		if err != nil {
+			return nil, err
+		}
+		redactor.patterns = append(redactor.patterns, pattern)
+	}
+	return redactor, nil
+}
+
+// Redact 返回替换敏感信息后的内容。
+func (r *Redactor) Redact(data []byte) []byte {
+	if r == nil {
+		return data
+	}
+	for _, pattern := range r.patterns {
+		if pattern.NumSubexp() == 0 {
+			data = pattern.ReplaceAllLiteral(data, []byte(redactedPlaceholder))
+			continue
+		}
+		// 重要逻辑：带捕获组的规则（如 password=xxx）保留键名，只替换值。
+		data = pattern.ReplaceAllFunc(data, func(match []byte) []byte {
+			loc := pattern.FindSubmatchIndex(match)
+			if len(loc) < 4 || loc[2] < 0 {
+				return []byte(redactedPlaceholder)
+			}
+			result := make([]byte, 0, len(match))
+			result = append(result, match[:loc[2]]...)
+			result = append(result, redactedPlaceholder...)
+			return append(result, match[loc[3]:]...)
+		})
+	}
+	return data
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxChunkSize         = 16 * 1024 * 1024
+	suggestedChunkSize   = 4 * 1024 * 1024
+	uploadPurgeInterval  = 10 * time.Minute
+	uploadPartSuffix     = ".part"
+	uploadMetadataSuffix = ".json"
+)
+
+// ResumableUpload 是一次分片上传的状态，分片数据保存在暂存目录中。
+// 登记后只有 ExpiresAt 会变化，读写都需持有 UploadManager.mu；mu 串行化同一上传的分片写入。
+// Stage 是暂存子目录，由元数据文件所在位置决定，不写入元数据。
+type ResumableUpload struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"session_id"`
+	Owner     string    `json:"owner,omitempty"`
+	Root      string    `json:"root"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Stage     string    `json:"-"`
+	mu        sync.Mutex
+}
+
+// UploadManager 管理分片上传，元数据与分片数据都落盘，服务重启后可继续上传。
+type UploadManager struct {
+	mu      sync.Mutex
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+	uploads map[string]*ResumableUpload
+}
+
+// InitUploadRequest 是创建分片上传的请求。
+type InitUploadRequest struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+// FinalizeUploadRequest 是完成分片上传的请求。
+type FinalizeUploadRequest struct {
+	Checksum string `json:"checksum"`
+}
+
+// NewUploadManager 创建 UploadManager，并加载暂存目录中未过期的上传。
+func NewUploadManager(dir string, ttl time.Duration, maxSize int64) (*UploadManager, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	manager := &UploadManager{dir: dir, ttl: ttl, maxSize: maxSize, uploads: make(map[string]*ResumableUpload)}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+uploadMetadataSuffix))
+	if err != nil {
+		return nil, err
+	}
+	// 重要逻辑：以会话账号上传的暂存数据位于按 UID 划分的子目录中。
+	accountPaths, err := filepath.Glob(filepath.Join(dir, "*", "*"+uploadMetadataSuffix))
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, accountPaths...)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		upload := &ResumableUpload{}
+		if err := json.Unmarshal(data, upload); err != nil || upload.ID == "" {
+			continue
+		}
+		// 重要逻辑：账号子目录中的元数据可被该账号修改，ID 与暂存子目录以文件位置为准，
+		// 防止清理时删除暂存目录之外的文件。
+		if upload.ID != strings.TrimSuffix(filepath.Base(path), uploadMetadataSuffix) {
+			continue
+		}
+		if _, err := uuid.Parse(upload.ID); err != nil {
+			continue
+		}
+		upload.Stage = ""
+		if stage := filepath.Dir(path); stage != filepath.Clean(dir) {
+			upload.Stage = filepath.Base(stage)
+		}
+		manager.uploads[upload.ID] = upload
+	}
+	manager.mu.Lock()
+	manager.purgeExpiredLocked()
+	manager.mu.Unlock()
+	return manager, nil
+}
+
+// PrepareAccounts 为各运行账号创建属于该账号的暂存子目录，使分片上传能以会话账号身份写入。
+func (u *UploadManager) PrepareAccounts(accounts []*RunAsAccount) error {
+	// 非 root 运行时无法切换账号，会话创建会直接失败，无需准备暂存子目录。
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	if len(accounts) == 0 {
+		return nil
+	}
+	// 重要逻辑：暂存根目录只开放进入权限，各账号只能访问自己的子目录，无法列出或读取其他上传。
+	if err := os.Chmod(u.dir, 0o711); err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		dir := filepath.Join(u.dir, accountStage(account))
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		if err := os.Chown(dir, int(account.UID), int(account.GID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountStage 返回账号的暂存子目录名，未切换账号时为空（直接使用暂存根目录）。
+func accountStage(account *RunAsAccount) string {
+	if account == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(account.UID), 10)
+}
+
+// Create 登记新的分片上传并创建空的分片文件。
+func (u *UploadManager) Create(upload *ResumableUpload) error {
+	upload.ID = uuid.NewString()
+	upload.CreatedAt = time.Now()
+	upload.ExpiresAt = upload.CreatedAt.Add(u.ttl)
+	part, err := os.OpenFile(u.partPath(upload), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_ = part.Close()
+	if err := u.save(upload); err != nil {
+		_ = os.Remove(u.partPath(upload))
+		return err
+	}
+	u.mu.Lock()
+	u.purgeExpiredLocked()
+	u.uploads[upload.ID] = upload
+	u.mu.Unlock()
+	return nil
+}
+
+// Get 返回未过期的上传。
+func (u *UploadManager) Get(id string) (*ResumableUpload, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload, ok := u.uploads[id]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(upload.ExpiresAt) {
+		u.removeLocked(id)
+		return nil, false
+	}
+	return upload, true
+}
+
+// Remove 删除上传的元数据与分片数据。
+func (u *UploadManager) Remove(id string) {
+	u.mu.Lock()
+	u.removeLocked(id)
+	u.mu.Unlock()
+}
+
+// Received 返回已接收的字节数。
+func (u *UploadManager) Received(upload *ResumableUpload) (int64, error) {
+	info, err := os.Stat(u.partPath(upload))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// touch 延长上传的过期时间并保存元数据。
+func (u *UploadManager) touch(upload *ResumableUpload) {
+	// 重要逻辑：ExpiresAt 与 Get、定期清理共用管理器的锁，序列化也在锁内完成；写文件放在锁外。
+	u.mu.Lock()
+	upload.ExpiresAt = time.Now().Add(u.ttl)
+	u.mu.Unlock()
+	data, err := u.marshal(upload)
+	if err == nil {
+		err = writeFileAtomic(u.metadataPath(upload), data, 0o600)
+	}
+	if err != nil {
+		log.Printf("save upload metadata failed: %s: %v", upload.ID, err)
+	}
+}
+
+// marshal 在管理器锁内序列化上传状态，避免与 touch 并发读写 ExpiresAt。
+func (u *UploadManager) marshal(upload *ResumableUpload) (json.RawMessage, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return json.Marshal(upload)
+}
+
+// purgeExpired 定期清理过期的分片上传。
+func (u *UploadManager) purgeExpired() {
+	ticker := time.NewTicker(uploadPurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		u.mu.Lock()
+		u.purgeExpiredLocked()
+		u.mu.Unlock()
+	}
+}
+
+func (u *UploadManager) purgeExpiredLocked() {
+	now := time.Now()
+	for id, upload := range u.uploads {
+		if !now.Before(upload.ExpiresAt) {
+			u.removeLocked(id)
+		}
+	}
+}
+
+func (u *UploadManager) removeLocked(id string) {
+	if upload, ok := u.uploads[id]; ok {
+		_ = os.Remove(u.partPath(upload))
+		_ = os.Remove(u.metadataPath(upload))
+	}
+	delete(u.uploads, id)
+}
+
+func (u *UploadManager) save(upload *ResumableUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(u.metadataPath(upload), data, 0o600)
+}
+
+func (u *UploadManager) partPath(upload *ResumableUpload) string {
+	return filepath.Join(u.dir, upload.Stage, upload.ID+uploadPartSuffix)
+}
+
+func (u *UploadManager) metadataPath(upload *ResumableUpload) string {
+	return filepath.Join(u.dir, upload.Stage, upload.ID+uploadMetadataSuffix)
+}
+
+// normalizeChecksum 统一校验和格式为 sha256:<hex>。
+func normalizeChecksum(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return ""
+	}
+	if !strings.HasPrefix(value, "sha256:") {
+		value = "sha256:" + value
+	}
+	return value
+}
+
+// fileChecksum 流式计算文件的 SHA-256。
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// authorizeUpload 返回当前用户创建的上传，其他用户的上传按不存在处理。
+func authorizeUpload(r *http.Request, uploads *UploadManager, id string) (*ResumableUpload, bool) {
+	upload, ok := uploads.Get(id)
+	if !ok {
+		return nil, false
+	}
+	owner := ""
+	if principal := requestPrincipal(r); principal != nil {
+		owner = principal.Username
+	}
+	if upload.Owner != owner {
+		return nil, false
+	}
+	return upload, true
+}
+
+// HandleUploadInit 创建分片上传，path 为目标目录，name 为相对该目录的文件路径。
+func HandleUploadInit(manager *SessionManager, uploads *UploadManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID := r.URL.Query().Get("session_id")
+		if sessionID == "" {
+			writeError(w, http.StatusBadRequest, "session_id required")
+			return
+		}
+		root, _, err := resolveFileRoot(r, manager, sessionID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		var payload InitUploadRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if payload.Name == "" || payload.Size < 0 {
+			writeError(w, http.StatusBadRequest, "name and size required")
+			return
+		}
+		if uploads.maxSize > 0 && payload.Size > uploads.maxSize {
+			writeErrorCode(w, http.StatusRequestEntityTooLarge, "too_large", "file too large")
+			return
+		}
+		target := uploadTarget{root: root, base: r.URL.Query().Get("path"), followSymlinks: manager.followSymlinks}
+		// 重要逻辑：创建时先校验目标路径，完成时还会再次校验，防止期间被替换为符号链接。
+		if _, err := target.resolve(filepath.FromSlash(payload.Name)); err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		owner := ""
+		if principal := requestPrincipal(r); principal != nil {
+			owner = principal.Username
+		}
+		upload := &ResumableUpload{
+			SessionID: sessionID,
+			Owner:     owner,
+			Root:      root,
+			Path:      filepath.ToSlash(filepath.Join(target.base, filepath.Clean(filepath.FromSlash(payload.Name)))),
+			Size:      payload.Size,
+			Checksum:  normalizeChecksum(payload.Checksum),
+			Stage:     accountStage(requestAccount(r)),
+		}
+		if err := uploads.Create(upload); err != nil {
+			writeError(w, http.StatusInternalServerError, "create upload failed")
+			return
+		}
+		data, err := uploads.marshal(upload)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "create upload failed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"upload":     data,
+			"offset":     0,
+			"chunk_size": suggestedChunkSize,
+		})
+	}
+}
+
+// HandleUploadChunk 按偏移量写入分片，offset 必须等于已接收的字节数。
+func HandleUploadChunk(uploads *UploadManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		upload, ok := authorizeUpload(r, uploads, r.URL.Query().Get("upload_id"))
+		// 重要逻辑：分片以 session_id 对应的会话账号写入暂存目录，必须与创建上传时的会话一致。
+		if !ok || r.URL.Query().Get("session_id") != upload.SessionID {
+			writeErrorCode(w, http.StatusNotFound, "not_found", "upload not found")
+			return
+		}
+		offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "invalid offset")
+			return
+		}
+		upload.mu.Lock()
+		defer upload.mu.Unlock()
+
+		received, err := uploads.Received(upload)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "read upload failed")
+			return
+		}
+		// 重要逻辑：偏移不一致时返回已接收的字节数，客户端据此从断点续传。
+		if offset != received {
+			writeJSON(w, http.StatusConflict, map[string]any{
+				"ok":      false,
+				"code":    "offset_mismatch",
+				"message": "offset does not match received bytes",
+				"offset":  received,
+			})
+			return
+		}
+		part, err := os.OpenFile(uploads.partPath(upload), os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "open upload failed")
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, maxChunkSize)
+		written, copyErr := io.Copy(part, io.LimitReader(body, upload.Size-received))
+		closeErr := part.Close()
+		uploads.touch(upload)
+		offset = received + written
+		if copyErr != nil || closeErr != nil {
+			// 重要逻辑：连接中断时已写入的部分保留，客户端查询 offset 后继续。
+			writeJSON(w, http.StatusBadRequest, map[string]any{
+				"ok":      false,
+				"code":    "chunk_incomplete",
+				"message": "chunk transfer interrupted",
+				"offset":  offset,
+			})
+			return
+		}
+		if extra, _ := body.Read(make([]byte, 1)); extra > 0 {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]any{
+				"ok":      false,
+				"code":    "exceeds_size",
+				"message": "chunk exceeds declared size",
+				"offset":  offset,
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "offset": offset, "size": upload.Size})
+	}
+}
+
+// HandleUploadStatus 返回分片上传的进度，用于断点续传。
+func HandleUploadStatus(uploads *UploadManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		upload, ok := authorizeUpload(r, uploads, r.URL.Query().Get("upload_id"))
+		if !ok {
+			writeErrorCode(w, http.StatusNotFound, "not_found", "upload not found")
+			return
+		}
+		upload.mu.Lock()
+		defer upload.mu.Unlock()
+		received, err := uploads.Received(upload)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "read upload failed")
+			return
+		}
+		data, err := uploads.marshal(upload)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "read upload failed")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"upload": data, "offset": received})
+	}
+}
+
+// HandleUploadFinalize 校验大小与校验和后将文件移动到目标位置。
+func HandleUploadFinalize(manager *SessionManager, uploads *UploadManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		upload, ok := authorizeUpload(r, uploads, r.URL.Query().Get("upload_id"))
+		if !ok {
+			writeErrorCode(w, http.StatusNotFound, "not_found", "upload not found")
+			return
+		}
+		// 重要逻辑：会话关闭后无法确定写入身份，拒绝完成而不是退回服务身份写入。
+		session, ok := authorizeSession(r, manager, upload.SessionID)
+		if !ok {
+			writeErrorCode(w, http.StatusConflict, "session_closed", "session closed")
+			return
+		}
+		conflict, ok := parseConflictPolicy(r.URL.Query().Get("conflict"))
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid conflict policy")
+			return
+		}
+		var payload FinalizeUploadRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && err != io.EOF {
+				writeError(w, http.StatusBadRequest, "invalid json")
+				return
+			}
+		}
+		upload.mu.Lock()
+		defer upload.mu.Unlock()
+
+		received, err := uploads.Received(upload)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "read upload failed")
+			return
+		}
+		if received != upload.Size {
+			writeJSON(w, http.StatusConflict, map[string]any{
+				"ok":      false,
+				"code":    "incomplete",
+				"message": "upload incomplete",
+				"offset":  received,
+			})
+			return
+		}
+		expected := normalizeChecksum(payload.Checksum)
+		if expected == "" {
+			expected = upload.Checksum
+		}
+		if expected == "" {
+			writeError(w, http.StatusBadRequest, "checksum required")
+			return
+		}
+		actual, err := fileChecksum(uploads.partPath(upload))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "read upload failed")
+			return
+		}
+		// 重要逻辑：校验失败说明数据已损坏，丢弃暂存数据，客户端需重新上传。
+		if actual != expected {
+			uploads.Remove(upload.ID)
+			writeErrorCode(w, http.StatusUnprocessableEntity, "checksum_mismatch", "checksum mismatch")
+			return
+		}
+		// 重要逻辑：先以服务身份打开分片数据，再以会话账号身份写入目标位置，
+		// 使权限检查与文件属主都与终端内一致。
+		part, err := os.Open(uploads.partPath(upload))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "read upload failed")
+			return
+		}
+		defer part.Close()
+		var final string
+		var result UploadResult
+		var saveErr error
+		account := session.account
+		// 重要逻辑：分片位于账号自己的暂存目录时可直接重命名，否则只能以会话账号身份复制。
+		copyOnly := account != nil && upload.Stage != accountStage(account)
+		if err := runWithAccount(account, func() {
+			final, result, saveErr = saveResumableUpload(manager, upload, part, conflict, copyOnly)
+		}); err != nil {
+			writeError(w, http.StatusInternalServerError, "switch user failed")
+			return
+		}
+		if saveErr != nil {
+			// 重要逻辑：冲突等失败时保留暂存数据，客户端可换用其他策略再次完成上传。
+			writeUploadError(w, saveErr)
+			return
+		}
+		uploads.Remove(upload.ID)
+		recordAudit(r, AuditEvent{Action: "file.upload", SessionID: upload.SessionID, Path: final, Success: true, Detail: map[string]any{"resumable": true, "files": []UploadResult{result}, "checksum": actual}})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "files": []UploadResult{result}, "checksum": actual})
+	}
+}
+
+// saveResumableUpload 按冲突策略将暂存数据写入目标位置，返回实际路径与处理结果。
+func saveResumableUpload(manager *SessionManager, upload *ResumableUpload, part *os.File, conflict string, copyOnly bool) (string, UploadResult, error) {
+	dest, err := resolveSafePath(upload.Root, filepath.FromSlash(upload.Path), manager.followSymlinks)
+	if err != nil {
+		return "", UploadResult{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", UploadResult{}, err
+	}
+	final, action, err := resolveConflict(dest, conflict)
+	if err != nil {
+		return "", UploadResult{}, err
+	}
+	result := UploadResult{
+		Path:   filepath.ToSlash(filepath.Join(filepath.Dir(filepath.FromSlash(upload.Path)), filepath.Base(final))),
+		Size:   upload.Size,
+		Action: action,
+	}
+	if action == "skipped" {
+		return final, result, nil
+	}
+	if err := installUploadFile(part, final, uploadFileMode(final, action), copyOnly); err != nil {
+		return "", UploadResult{}, err
+	}
+	return final, result, nil
+}
+
+// installUploadFile 将暂存数据移动到目标路径；跨文件系统或以会话账号写入时先复制到目标目录的临时文件再重命名。
+func installUploadFile(part *os.File, dest string, perm os.FileMode, copyOnly bool) error {
+	if !copyOnly {
+		if err := os.Chmod(part.Name(), perm); err != nil {
+			return err
+		}
+		err := os.Rename(part.Name(), dest)
+		if err == nil || !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+	}
+	_, err := writeUploadFile(dest, part, perm)
+	return err
+}
+
+// HandleUploadCancel 取消分片上传并删除暂存数据。
+func HandleUploadCancel(uploads *UploadManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		upload, ok := authorizeUpload(r, uploads, r.URL.Query().Get("upload_id"))
+		if !ok {
+			writeErrorCode(w, http.StatusNotFound, "not_found", "upload not found")
+			return
+		}
+		uploads.Remove(upload.ID)
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
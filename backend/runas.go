@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// RunAsAccount 描述会话 shell 运行所使用的系统账号。
+type RunAsAccount struct {
+	Spec    string
+	User    string
+	UID     uint32
+	GID     uint32
+	Groups  []uint32
+	HomeDir string
+}
+
+// lookupRunAsAccount 解析 user[:group] 形式的账号配置。
+func lookupRunAsAccount(spec string) (*RunAsAccount, error) {
+	name, group := spec, ""
+	if idx := strings.IndexByte(spec, ':'); idx >= 0 {
+		name, group = spec[:idx], spec[idx+1:]
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %q", name)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid for %q", name)
+	}
+	gidText := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil, fmt.Errorf("unknown group %q", group)
+		}
+		gidText = g.Gid
+	}
+	gid, err := strconv.ParseUint(gidText, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid for %q", spec)
+	}
+	account := &RunAsAccount{
+		Spec:    spec,
+		User:    u.Username,
+		UID:     uint32(uid),
+		GID:     uint32(gid),
+		HomeDir: u.HomeDir,
+	}
+	// 重要逻辑：保留账号的附加组，保证 docker 等组权限在会话内可用。
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if parsed, err := strconv.ParseUint(id, 10, 32); err == nil {
+				account.Groups = append(account.Groups, uint32(parsed))
+			}
+		}
+	}
+	return account, nil
+}
+
+// runAsAccounts 返回默认运行账号与允许切换的账号中能解析的部分。
+func (m *SessionManager) runAsAccounts() []*RunAsAccount {
+	specs := []string{m.runAs}
+	for spec := range m.runAsAllowed {
+		specs = append(specs, spec)
+	}
+	var accounts []*RunAsAccount
+	for _, spec := range specs {
+		if spec == "" {
+			continue
+		}
+		if account, err := lookupRunAsAccount(spec); err == nil {
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// applyRunAsAccount 配置命令以指定账号运行，并调整 HOME 等环境变量。
+func applyRunAsAccount(cmd *exec.Cmd, account *RunAsAccount) error {
+	if os.Geteuid() != 0 {
+		return errors.New("run as another user requires root")
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    account.UID,
+		Gid:    account.GID,
+		Groups: account.Groups,
+	}
+	overrides := map[string]string{
+		"HOME":    account.HomeDir,
+		"USER":    account.User,
+		"LOGNAME": account.User,
+	}
+	env := make([]string, 0, len(cmd.Env))
+	for _, pair := range cmd.Env {
+		key := strings.SplitN(pair, "=", 2)[0]
+		if _, ok := overrides[key]; ok {
+			continue
+		}
+		env = append(env, pair)
+	}
+	for key, value := range overrides {
+		env = append(env, key+"="+value)
+	}
+	cmd.Env = env
+	if isDirectory(account.HomeDir) {
+		cmd.Dir = account.HomeDir
+	}
+	return nil
+}
+
+// sessionAccountKey 是请求上下文中会话账号的键。
+type sessionAccountKey struct{}
+
+// withSessionAccount 让文件与 Git 接口以会话账号的文件系统身份执行：
+// 访问权限与终端内一致，新建的文件也归该账号所有。
+func withSessionAccount(manager *SessionManager, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account := manager.sessionAccount(r.URL.Query().Get("session_id"))
+		if account == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), sessionAccountKey{}, account))
+		if err := runWithAccount(account, func() { next.ServeHTTP(w, r) }); err != nil {
+			writeError(w, http.StatusInternalServerError, "switch user failed")
+		}
+	}
+}
+
+// requestAccount 返回当前请求对应的会话账号，会话未配置运行账号时为 nil。
+func requestAccount(r *http.Request) *RunAsAccount {
+	account, _ := r.Context().Value(sessionAccountKey{}).(*RunAsAccount)
+	return account
+}
+
+// runWithAccount 在切换为账号文件系统身份的独立线程上执行 fn，fn 中的 panic 会转交给调用方。
+func runWithAccount(account *RunAsAccount, fn func()) error {
+	if account == nil {
+		fn()
+		return nil
+	}
+	type outcome struct {
+		err      error
+		panicked any
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		// 重要逻辑：锁定线程且不解锁，goroutine 结束时线程随之退出，切换过身份的线程不会被复用。
+		runtime.LockOSThread()
+		result := outcome{}
+		defer func() {
+			result.panicked = recover()
+			done <- result
+		}()
+		if result.err = setThreadFSCredential(account); result.err != nil {
+			return
+		}
+		fn()
+	}()
+	result := <-done
+	if result.panicked != nil {
+		panic(result.panicked)
+	}
+	return result.err
+}
+
+// setThreadFSCredential 只修改当前线程的附加组与 fsuid/fsgid，内核会随之移除该线程绕过文件权限的能力。
+func setThreadFSCredential(account *RunAsAccount) error {
+	groups := append([]uint32{account.GID}, account.Groups...)
+	// 重要逻辑：syscall.Setgroups 会作用于所有线程，这里直接发起系统调用只影响当前线程。
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETGROUPS, uintptr(len(groups)), uintptr(unsafe.Pointer(&groups[0])), 0); errno != 0 {
+		return errno
+	}
+	_, _, _ = syscall.RawSyscall(syscall.SYS_SETFSGID, uintptr(account.GID), 0, 0)
+	_, _, _ = syscall.RawSyscall(syscall.SYS_SETFSUID, uintptr(account.UID), 0, 0)
+	// setfsuid/setfsgid 不返回错误，传入 -1 读取当前值确认切换成功。
+	gid, _, _ := syscall.RawSyscall(syscall.SYS_SETFSGID, uintptr(^uint32(0)), 0, 0)
+	uid, _, _ := syscall.RawSyscall(syscall.SYS_SETFSUID, uintptr(^uint32(0)), 0, 0)
+	if uint32(uid) != account.UID || uint32(gid) != account.GID {
+		return errors.New("switch filesystem credential failed")
+	}
+	return nil
+}
+
+// accountCommand 创建以会话账号运行的命令，未配置账号时以服务自身身份运行。
+func accountCommand(account *RunAsAccount, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	if account != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{
+			Uid:    account.UID,
+			Gid:    account.GID,
+			Groups: account.Groups,
+		}}
+		cmd.Env = append(os.Environ(), "HOME="+account.HomeDir, "USER="+account.User)
+	}
+	return cmd
+}
+
+// chownSessionTTY 将 PTY 从设备交给会话账号，使 sudo/ssh 能打开 /dev/tty。
+func chownSessionTTY(ptmx *os.File, account *RunAsAccount) error {
+	var index uint32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), uintptr(syscall.TIOCGPTN), uintptr(unsafe.Pointer(&index)))
+	if errno != 0 {
+		return errno
+	}
+	return os.Chown(fmt.Sprintf("/dev/pts/%d", index), int(account.UID), int(account.GID))
+}
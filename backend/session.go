@@ -2,7 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,10 +21,29 @@ type SessionResponse struct {
 
 // SessionInfo 是会话列表信息。
 type SessionInfo struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	DisplayIndex int       `json:"display_index"`
-	LastActive   time.Time `json:"last_active"`
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	DisplayIndex int             `json:"display_index"`
+	LastActive   time.Time       `json:"last_active"`
+	Foreground   string          `json:"foreground,omitempty"`
+	Limits       *ResourceLimits `json:"limits,omitempty"`
+	Usage        *ResourceUsage  `json:"usage,omitempty"`
+	ExpiresAt    *time.Time      `json:"expires_at,omitempty"`
+	RunAs        string          `json:"run_as,omitempty"`
+	Owner        string          `json:"owner,omitempty"`
+	SharedWith   []string        `json:"shared_with,omitempty"`
+}
+
+// SessionMembersRequest 是设置会话共享用户的请求。
+type SessionMembersRequest struct {
+	SessionID string   `json:"session_id"`
+	Users     []string `json:"users"`
+}
+
+// CreateSessionRequest 是创建会话的可选请求体。
+type CreateSessionRequest struct {
+	Limits ResourceLimits `json:"limits"`
+	RunAs  string         `json:"run_as"`
 }
 
 // CloseSessionRequest 是关闭会话的请求。
@@ -34,21 +57,66 @@ type RenameSessionRequest struct {
 	Name      string `json:"name"`
 }
 
+// SignalSessionRequest 是向会话进程发送信号或控制键的请求。
+type SignalSessionRequest struct {
+	SessionID string `json:"session_id"`
+	Signal    string `json:"signal"`
+	PID       int    `json:"pid"`
+	Key       string `json:"key"`
+}
+
+// controlKeys 是可以直接写入 PTY 的控制键。
+var controlKeys = map[string]string{
+	"ctrl-c":  "\x03",
+	"ctrl-d":  "\x04",
+	"ctrl-z":  "\x1a",
+	"ctrl-\\": "\x1c",
+	"esc":     "\x1b",
+}
+
 // HandleCreateSession 创建新的 PTY 会话并返回连接信息。
-func HandleCreateSession(manager *SessionManager) http.HandlerFunc {
+func HandleCreateSession(manager *SessionManager, limiter *RateLimiter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
 		}
+		// 重要逻辑：限制单个 IP 创建会话的频率，避免被脚本刷出大量 shell。
+		if retryAfter, ok := limiter.Reserve(requestClientIP(r)); !ok {
+			writeRateLimited(w, retryAfter)
+			return
+		}
+
+		var payload CreateSessionRequest
+		// 重要逻辑：请求体可选，兼容不带参数的创建请求。
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && err != io.EOF {
+				writeError(w, http.StatusBadRequest, "invalid json")
+				return
+			}
+		}
 
 		sessionID := uuid.NewString()
-		session, err := manager.CreateSession(sessionID)
+		owner := ""
+		principal := requestPrincipal(r)
+		if principal != nil {
+			owner = principal.Username
+		}
+		session, err := manager.CreateSession(sessionID, SessionOptions{Limits: payload.Limits, RunAs: payload.RunAs, Owner: owner, Principal: principal})
+		if errors.Is(err, errRunAsNotAllowed) {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if errors.Is(err, errLimitExceedsCap) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if err != nil {
 			writeError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
 
+		recordAudit(r, AuditEvent{Action: "session.create", SessionID: sessionID, Success: true, Detail: map[string]any{"run_as": session.RunAs}})
 		wsURL := buildWSURL(r, sessionID)
 		response := SessionResponse{SessionID: sessionID, WSURL: wsURL, Name: session.Name}
 		writeJSON(w, http.StatusOK, response)
@@ -72,11 +140,21 @@ func HandleCloseSession(manager *SessionManager) http.HandlerFunc {
 			writeError(w, http.StatusBadRequest, "session_id required")
 			return
 		}
+		session, ok := authorizeSession(r, manager, payload.SessionID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		if !isSessionOwner(session, requestPrincipal(r)) {
+			writeError(w, http.StatusForbidden, "only owner can close session")
+			return
+		}
 
 		if err := manager.CloseSession(payload.SessionID); err != nil {
 			writeError(w, http.StatusNotFound, err.Error())
 			return
 		}
+		recordAudit(r, AuditEvent{Action: "session.close", SessionID: payload.SessionID, Success: true})
 
 		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 	}
@@ -99,11 +177,16 @@ func HandleRenameSession(manager *SessionManager) http.HandlerFunc {
 			writeError(w, http.StatusBadRequest, "session_id and name required")
 			return
 		}
+		if _, ok := authorizeSession(r, manager, payload.SessionID); !ok {
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
 
 		if err := manager.RenameSession(payload.SessionID, payload.Name); err != nil {
 			writeError(w, http.StatusNotFound, err.Error())
 			return
 		}
+		recordAudit(r, AuditEvent{Action: "session.rename", SessionID: payload.SessionID, Success: true, Detail: map[string]any{"name": payload.Name}})
 
 		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 	}
@@ -117,11 +200,167 @@ func HandleListSessions(manager *SessionManager) http.HandlerFunc {
 			return
 		}
 
-		list := manager.ListSessions()
+		list := manager.ListSessions(requestPrincipal(r))
 		writeJSON(w, http.StatusOK, map[string]any{"sessions": list})
 	}
 }
 
+// HandleSessionProcesses 返回会话的前台进程与完整进程树。
+func HandleSessionProcesses(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID := r.URL.Query().Get("session_id")
+		if sessionID == "" {
+			writeError(w, http.StatusBadRequest, "session_id required")
+			return
+		}
+		session, ok := authorizeSession(r, manager, sessionID)
+		if !ok || session.Cmd == nil || session.Cmd.Process == nil {
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		session.mu.Lock()
+		ptmx := session.PTY
+		session.mu.Unlock()
+
+		tree, err := buildProcessTree(session.Cmd.Process.Pid)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "session process exited")
+			return
+		}
+		// 重要逻辑：前台进程获取失败不影响进程树返回。
+		foreground, _ := resolveForegroundProcess(ptmx)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"foreground": foreground,
+			"tree":       tree,
+		})
+	}
+}
+
+// HandleSignalSession 向会话前台进程组或指定进程发送信号，或写入控制键。
+func HandleSignalSession(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var payload SignalSessionRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if payload.SessionID == "" || (payload.Signal == "" && payload.Key == "") {
+			writeError(w, http.StatusBadRequest, "session_id and signal or key required")
+			return
+		}
+		session, ok := authorizeSession(r, manager, payload.SessionID)
+		if !ok || session.Cmd == nil || session.Cmd.Process == nil {
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		session.mu.Lock()
+		ptmx := session.PTY
+		session.mu.Unlock()
+
+		if payload.Key != "" {
+			sequence, ok := controlKeys[strings.ToLower(payload.Key)]
+			if !ok {
+				writeError(w, http.StatusBadRequest, "unsupported key")
+				return
+			}
+			if _, err := ptmx.Write([]byte(sequence)); err != nil {
+				writeError(w, http.StatusInternalServerError, "write key failed")
+				return
+			}
+			recordAudit(r, AuditEvent{Action: "session.signal", SessionID: payload.SessionID, Success: true, Detail: map[string]any{"key": payload.Key}})
+			writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+			return
+		}
+
+		sig, ok := parseSessionSignal(payload.Signal)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "unsupported signal")
+			return
+		}
+		if payload.PID > 0 {
+			// 重要逻辑：只允许向当前会话进程树内的进程发信号，防止误杀其他进程。
+			tree, err := buildProcessTree(session.Cmd.Process.Pid)
+			if err != nil || !processTreeContains(tree, payload.PID) {
+				writeError(w, http.StatusForbidden, "pid not in session")
+				return
+			}
+			if err := syscall.Kill(payload.PID, sig); err != nil {
+				writeError(w, http.StatusInternalServerError, "send signal failed")
+				return
+			}
+			recordAudit(r, AuditEvent{Action: "session.signal", SessionID: payload.SessionID, Success: true, Detail: map[string]any{"signal": payload.Signal, "pid": payload.PID}})
+			writeJSON(w, http.StatusOK, map[string]any{"ok": true, "pid": payload.PID})
+			return
+		}
+		pgid, err := foregroundPGID(ptmx)
+		if err != nil || pgid <= 0 {
+			writeError(w, http.StatusNotFound, "no foreground process")
+			return
+		}
+		if err := syscall.Kill(-pgid, sig); err != nil {
+			writeError(w, http.StatusInternalServerError, "send signal failed")
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "session.signal", SessionID: payload.SessionID, Success: true, Detail: map[string]any{"signal": payload.Signal, "pgid": pgid}})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "pgid": pgid})
+	}
+}
+
+// HandleSessionMembers 设置会话的共享用户（仅所有者或管理员）。
+func HandleSessionMembers(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var payload SessionMembersRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if payload.SessionID == "" {
+			writeError(w, http.StatusBadRequest, "session_id required")
+			return
+		}
+		session, ok := authorizeSession(r, manager, payload.SessionID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		if !isSessionOwner(session, requestPrincipal(r)) {
+			writeError(w, http.StatusForbidden, "only owner can share session")
+			return
+		}
+
+		if err := manager.SetSessionMembers(payload.SessionID, payload.Users); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "session.members", SessionID: payload.SessionID, Success: true, Detail: map[string]any{"users": payload.Users}})
+
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
+
+// authorizeSession 返回当前请求有权访问的会话，无权访问时与不存在一样处理。
+func authorizeSession(r *http.Request, manager *SessionManager, sessionID string) (*Session, bool) {
+	session, ok := manager.GetSession(sessionID)
+	if !ok || !canAccessSession(session, requestPrincipal(r)) {
+		return nil, false
+	}
+	return session, true
+}
+
 // buildWSURL 生成 WebSocket 连接地址。
 func buildWSURL(r *http.Request, sessionID string) string {
 	scheme := "ws"
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"sort"
@@ -22,13 +23,49 @@ type Session struct {
 	PTY          *os.File
 	Buffer       *RingBuffer
 	LastActive   time.Time
+	CWD          string
+	Limits       ResourceLimits
+	Cgroup       string
+	RunAs        string
+	Owner        string
+	SharedWith   []string
+	ExpiresAt    time.Time
+	cwdReported  bool
+	cwdParser    OSC7Parser
+	cmdParser    CommandMarkerParser
+	audit        *AuditLogger
+	timeout      *time.Timer
+	account      *RunAsAccount
+	output       sessionOutput
 	mu           sync.Mutex
 }
 
+// SessionOptions 是创建会话时的可选参数。
+type SessionOptions struct {
+	Limits    ResourceLimits
+	RunAs     string
+	Owner     string
+	Principal *Principal
+}
+
+// errRunAsNotAllowed 表示请求的运行账号不在允许列表内。
+var errRunAsNotAllowed = errors.New("run_as not allowed")
+
 // SessionManager 管理所有会话。
 type SessionManager struct {
 	shell            string
 	bufferSize       int
+	limits           ResourceLimits
+	cgroupRoot       string
+	runAs            string
+	runAsAllowed     map[string]bool
+	followSymlinks   bool
+	roots            []WorkspaceRoot
+	trashRetention   time.Duration
+	excludes         []string
+	audit            *AuditLogger
+	trashMu          sync.Mutex
+	trashRoots       map[string]bool
 	mu               sync.RWMutex
 	sessions         map[string]*Session
 	nextDisplayIndex int
@@ -36,26 +73,87 @@ type SessionManager struct {
 	nameSeq          int
 }
 
-// NewSessionManager 创建 SessionManager。
-func NewSessionManager(shell string, bufferSize int) *SessionManager {
+// NewSessionManager 创建 SessionManager，audit 用于记录 shell 集成上报的命令。
+func NewSessionManager(cfg Config, audit *AuditLogger) *SessionManager {
+	runAsAllowed := make(map[string]bool, len(cfg.RunAsAllowed))
+	for _, spec := range cfg.RunAsAllowed {
+		runAsAllowed[spec] = true
+	}
 	return &SessionManager{
-		shell:      shell,
-		bufferSize: bufferSize,
-		sessions:   make(map[string]*Session),
+		shell:          cfg.Shell,
+		bufferSize:     cfg.BufferSize,
+		limits:         cfg.Limits,
+		cgroupRoot:     cfg.CgroupRoot,
+		runAs:          cfg.RunAs,
+		runAsAllowed:   runAsAllowed,
+		followSymlinks: cfg.FollowSymlinks,
+		roots:          loadWorkspaceRoots(cfg.WorkspaceRoots),
+		trashRetention: cfg.TrashRetention,
+		excludes:       cfg.FSExcludes,
+		audit:          audit,
+		sessions:       make(map[string]*Session),
 	}
 }
 
 // CreateSession 创建新的 PTY 会话。
-func (m *SessionManager) CreateSession(id string) (*Session, error) {
+func (m *SessionManager) CreateSession(id string, options SessionOptions) (*Session, error) {
+	// 重要逻辑：请求只能收紧运维配置的资源上限，超过上限直接拒绝。
+	if err := m.limits.CheckWithin(options.Limits); err != nil {
+		return nil, err
+	}
+	limits := m.limits.Merge(options.Limits)
+	runAs := options.RunAs
+	if runAs == "" {
+		runAs = m.runAs
+	}
+	// 重要逻辑：请求只能选择允许列表中且分配给当前用户的账号，默认账号始终可用。
+	if runAs != m.runAs && (!m.runAsAllowed[runAs] || !options.Principal.MayRunAs(runAs)) {
+		return nil, errRunAsNotAllowed
+	}
+	var account *RunAsAccount
+	if runAs != "" {
+		resolved, err := lookupRunAsAccount(runAs)
+		if err != nil {
+			return nil, err
+		}
+		account = resolved
+	}
+
 	cmd := exec.Command(m.shell)
 	// 重要逻辑：注入颜色相关环境，避免 NO_COLOR 导致的颜色禁用。
 	cmd.Env = buildColorEnv()
+	if account != nil {
+		if err := applyRunAsAccount(cmd, account); err != nil {
+			return nil, err
+		}
+	}
 	ptmx, err := pty.Start(cmd)
 	if err != nil {
 		return nil, err
 	}
+	if account != nil {
+		_ = chownSessionTTY(ptmx, account)
+	}
 	// 重要逻辑：初始化一个合理的终端尺寸，避免列数为 1 导致逐字换行。
 	_ = pty.Setsize(ptmx, &pty.Winsize{Cols: 120, Rows: 30})
+	cgroupPath := ""
+	if !limits.IsZero() {
+		// 重要逻辑：shell 刚启动尚未执行用户命令，此时施加限制可覆盖后续所有子进程。
+		cgroupPath, err = createSessionCgroup(m.cgroupRoot, id, cmd.Process.Pid, limits)
+		if err != nil {
+			log.Printf("session %s cgroup unavailable, fallback to rlimit: %v", id, err)
+		}
+		if err := applyRlimits(cmd.Process.Pid, limits, cgroupPath != ""); err != nil {
+			// 重要逻辑：shell 尚未执行用户命令，无需逐级等待，直接 SIGKILL 并在后台回收与删除 cgroup。
+			_ = ptmx.Close()
+			killSessionProcess(cmd, func() {
+				if err := removeSessionCgroup(cgroupPath); err != nil {
+					log.Printf("session %s cleanup failed: %v", id, err)
+				}
+			})
+			return nil, err
+		}
+	}
 	m.mu.Lock()
 	// 重要逻辑：确保名称计数器在同一个锁内更新，避免并发重复。
 	name := m.nextSessionNameLocked()
@@ -71,10 +169,26 @@ func (m *SessionManager) CreateSession(id string) (*Session, error) {
 		Buffer:       NewRingBuffer(m.bufferSize),
 		LastActive:   time.Now(),
 		DisplayIndex: m.nextDisplayIndex,
+		Limits:       limits,
+		Cgroup:       cgroupPath,
+		RunAs:        runAs,
+		Owner:        options.Owner,
+		account:      account,
+		audit:        m.audit,
+	}
+	if limits.TimeoutSeconds > 0 {
+		// 重要逻辑：超过运行时长后自动关闭会话，防止失控任务长期占用资源。
+		timeout := time.Duration(limits.TimeoutSeconds) * time.Second
+		session.ExpiresAt = session.LastActive.Add(timeout)
+		session.timeout = time.AfterFunc(timeout, func() {
+			_ = m.CloseSession(id)
+		})
 	}
 	m.nextDisplayIndex++
 	m.sessions[id] = session
 	m.mu.Unlock()
+	// 重要逻辑：会话创建即开始读取输出，无连接时输出同样进入缓存，不会阻塞 shell。
+	session.startOutputPump()
 
 	return session, nil
 }
@@ -131,21 +245,55 @@ func (m *SessionManager) GetSession(id string) (*Session, bool) {
 	return session, ok
 }
 
-// ListSessions 返回所有会话的快照信息。
-func (m *SessionManager) ListSessions() []SessionInfo {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// sessionAccount 返回会话的运行账号，会话不存在或以服务自身身份运行时返回 nil。
+func (m *SessionManager) sessionAccount(id string) *RunAsAccount {
+	session, ok := m.GetSession(id)
+	if !ok {
+		return nil
+	}
+	return session.account
+}
 
-	result := make([]SessionInfo, 0, len(m.sessions))
+// ListSessions 返回指定身份可见会话的快照信息，principal 为 nil 时返回全部。
+func (m *SessionManager) ListSessions(principal *Principal) []SessionInfo {
+	// 重要逻辑：只在锁内复制会话列表，读取资源占用可能扫描 /proc，不能阻塞会话的创建与关闭。
+	m.mu.RLock()
+	sessions := make([]*Session, 0, len(m.sessions))
 	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	m.mu.RUnlock()
+
+	result := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		if !canAccessSession(session, principal) {
+			continue
+		}
 		session.mu.Lock()
 		info := SessionInfo{
 			ID:           session.ID,
 			Name:         session.Name,
 			DisplayIndex: session.DisplayIndex,
 			LastActive:   session.LastActive,
+			RunAs:        session.RunAs,
+			Owner:        session.Owner,
+			SharedWith:   append([]string(nil), session.SharedWith...),
+		}
+		if !session.Limits.IsZero() {
+			limits := session.Limits
+			info.Limits = &limits
 		}
+		if !session.ExpiresAt.IsZero() {
+			expiresAt := session.ExpiresAt
+			info.ExpiresAt = &expiresAt
+		}
+		ptmx := session.PTY
+		cgroupPath := session.Cgroup
 		session.mu.Unlock()
+		if pgid, err := foregroundPGID(ptmx); err == nil && pgid > 0 {
+			info.Foreground = readProcComm(pgid)
+		}
+		info.Usage = readSessionUsage(session, cgroupPath)
 		result = append(result, info)
 	}
 
@@ -173,16 +321,71 @@ func (m *SessionManager) CloseSession(id string) error {
 	session.mu.Lock()
 	defer session.mu.Unlock()
 
+	if session.timeout != nil {
+		session.timeout.Stop()
+	}
+	// 重要逻辑：关闭 PTY 前先记录前台进程组，之后无法再查询。
+	foreground, _ := foregroundPGID(session.PTY)
 	if session.PTY != nil {
 		_ = session.PTY.Close()
 	}
 	if session.Cmd != nil && session.Cmd.Process != nil {
-		_ = session.Cmd.Process.Kill()
+		cmd := session.Cmd
+		cgroupPath := session.Cgroup
+		go func() {
+			if err := terminateSessionProcess(cmd, foreground, cgroupPath); err != nil {
+				log.Printf("session %s cleanup failed: %v", id, err)
+			}
+		}()
 	}
 
 	return nil
 }
 
+// SetSessionMembers 更新会话的共享用户列表。
+func (m *SessionManager) SetSessionMembers(id string, users []string) error {
+	m.mu.RLock()
+	session, ok := m.sessions[id]
+	m.mu.RUnlock()
+	if !ok {
+		return errors.New("session not found")
+	}
+
+	session.mu.Lock()
+	session.SharedWith = append([]string(nil), users...)
+	session.mu.Unlock()
+
+	return nil
+}
+
+// canAccessSession 判断身份是否可以访问会话：管理员、所有者或被共享的用户。
+func canAccessSession(session *Session, principal *Principal) bool {
+	if principal == nil || principal.IsAdmin() {
+		return true
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.Owner == principal.Username {
+		return true
+	}
+	for _, username := range session.SharedWith {
+		if username == principal.Username {
+			return true
+		}
+	}
+	return false
+}
+
+// isSessionOwner 判断身份是否可以管理会话（关闭、共享）：管理员或所有者。
+func isSessionOwner(session *Session, principal *Principal) bool {
+	if principal == nil || principal.IsAdmin() {
+		return true
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.Owner == principal.Username
+}
+
 // RenameSession 更新会话名称。
 func (m *SessionManager) RenameSession(id, name string) error {
 	m.mu.RLock()
@@ -198,3 +401,20 @@ func (m *SessionManager) RenameSession(id, name string) error {
 
 	return nil
 }
+
+// readSessionUsage 读取会话资源占用，优先使用 cgroup 统计。
+func readSessionUsage(session *Session, cgroupPath string) *ResourceUsage {
+	if cgroupPath != "" {
+		if usage, err := readCgroupUsage(cgroupPath); err == nil {
+			return usage
+		}
+	}
+	if session.Cmd == nil || session.Cmd.Process == nil {
+		return nil
+	}
+	usage, err := readProcessTreeUsage(session.Cmd.Process.Pid)
+	if err != nil {
+		return nil
+	}
+	return usage
+}
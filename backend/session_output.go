@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// outputSubscriberBuffer 是每个订阅连接可积压的输出块数量。
+const outputSubscriberBuffer = 256
+
+// errOutputOverflow 表示订阅连接消费过慢，积压超过上限后被断开。
+var errOutputOverflow = errors.New("output backlog overflow")
+
+// outputSubscriber 是会话实时输出的一个订阅连接。
+type outputSubscriber struct {
+	ch  chan []byte
+	err error
+}
+
+// Err 返回通道关闭的原因，仅在通道关闭后调用；PTY 正常结束时为 nil。
+func (s *outputSubscriber) Err() error {
+	return s.err
+}
+
+// sessionOutput 维护会话输出的订阅者，PTY 只由一个读取协程读取并分发给所有连接。
+type sessionOutput struct {
+	mu          sync.Mutex
+	subscribers map[*outputSubscriber]struct{}
+	closed      bool
+	err         error
+}
+
+// startOutputPump 启动会话唯一的 PTY 读取协程。
+func (s *Session) startOutputPump() {
+	s.output.subscribers = make(map[*outputSubscriber]struct{})
+	go s.pumpOutput()
+}
+
+// pumpOutput 持续读取 PTY 输出，写入缓存后分发给当前订阅的连接。
+func (s *Session) pumpOutput() {
+	buffer := make([]byte, 4096)
+	for {
+		n, readErr := s.PTY.Read(buffer)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buffer[:n])
+			trackSessionCWD(s, chunk)
+			trackSessionCommands(s, chunk)
+			s.output.mu.Lock()
+			// 重要逻辑：写缓存与分发在同一把锁内完成，保证新订阅者的回放与实时输出不重不漏。
+			s.Buffer.Write(chunk)
+			for sub := range s.output.subscribers {
+				select {
+				case sub.ch <- chunk:
+				default:
+					// 重要逻辑：慢连接不能阻塞其他连接与 shell，积压满后断开，重连时通过回放补齐。
+					sub.err = errOutputOverflow
+					close(sub.ch)
+					delete(s.output.subscribers, sub)
+				}
+			}
+			s.output.mu.Unlock()
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				readErr = nil
+			}
+			s.output.mu.Lock()
+			s.output.closed = true
+			s.output.err = readErr
+			for sub := range s.output.subscribers {
+				sub.err = readErr
+				close(sub.ch)
+				delete(s.output.subscribers, sub)
+			}
+			s.output.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Subscribe 订阅会话实时输出，返回订阅前的缓存快照与取消订阅函数。
+func (s *Session) Subscribe(redactor *Redactor) ([]byte, *outputSubscriber, func()) {
+	sub := &outputSubscriber{ch: make(chan []byte, outputSubscriberBuffer)}
+	s.output.mu.Lock()
+	defer s.output.mu.Unlock()
+	cached := sessionTranscript(s, redactor)
+	if s.output.closed {
+		sub.err = s.output.err
+		close(sub.ch)
+		return cached, sub, func() {}
+	}
+	s.output.subscribers[sub] = struct{}{}
+	unsubscribe := func() {
+		s.output.mu.Lock()
+		defer s.output.mu.Unlock()
+		if _, ok := s.output.subscribers[sub]; ok {
+			delete(s.output.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+	return cached, sub, unsubscribe
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultShareTTL = time.Hour
+	maxShareTTL     = 7 * 24 * time.Hour
+)
+
+// ShareLink 是绑定单个会话的临时访问令牌。
+type ShareLink struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"session_id"`
+	ReadOnly  bool      `json:"read_only"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	token     string
+	done      chan struct{}
+}
+
+// ShareManager 管理会话分享令牌。
+type ShareManager struct {
+	mu    sync.Mutex
+	links map[string]*ShareLink
+	byID  map[string]*ShareLink
+}
+
+// CreateShareRequest 是创建分享链接的请求。
+type CreateShareRequest struct {
+	SessionID  string `json:"session_id"`
+	TTLSeconds int    `json:"ttl_seconds"`
+	ReadOnly   bool   `json:"read_only"`
+}
+
+// RevokeShareRequest 是撤销分享链接的请求。
+type RevokeShareRequest struct {
+	ID string `json:"id"`
+}
+
+// NewShareManager 创建 ShareManager。
+func NewShareManager() *ShareManager {
+	return &ShareManager{
+		links: make(map[string]*ShareLink),
+		byID:  make(map[string]*ShareLink),
+	}
+}
+
+// Create 为会话生成新的分享令牌，返回链接与令牌明文。
+func (s *ShareManager) Create(sessionID, createdBy string, ttl time.Duration, readOnly bool) (*ShareLink, string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := randomToken(9)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	link := &ShareLink{
+		ID:        id,
+		SessionID: sessionID,
+		ReadOnly:  readOnly,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		token:     token,
+		done:      make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.purgeExpiredLocked()
+	s.links[token] = link
+	s.byID[id] = link
+	s.mu.Unlock()
+	return link, token, nil
+}
+
+// Resolve 校验分享令牌并返回对应链接。
+func (s *ShareManager) Resolve(token string) (*ShareLink, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.links[token]
+	if !ok || !time.Now().Before(link.ExpiresAt) {
+		return nil, false
+	}
+	return link, true
+}
+
+// List 返回未过期的分享链接，sessionID 为空时返回全部。
+func (s *ShareManager) List(sessionID string) []ShareLink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpiredLocked()
+	result := make([]ShareLink, 0, len(s.byID))
+	for _, link := range s.byID {
+		if sessionID != "" && link.SessionID != sessionID {
+			continue
+		}
+		result = append(result, *link)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// Get 按 ID 返回分享链接。
+func (s *ShareManager) Get(id string) (*ShareLink, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.byID[id]
+	return link, ok
+}
+
+// Revoke 撤销分享链接，并断开通过该链接建立的连接。
+func (s *ShareManager) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.byID[id]
+	if !ok {
+		return errors.New("share not found")
+	}
+	s.removeLocked(link)
+	return nil
+}
+
+// purgeExpiredLocked 清理过期链接（需要在锁内调用）。
+func (s *ShareManager) purgeExpiredLocked() {
+	now := time.Now()
+	for _, link := range s.byID {
+		if !now.Before(link.ExpiresAt) {
+			s.removeLocked(link)
+		}
+	}
+}
+
+// removeLocked 删除链接并通知相关连接（需要在锁内调用）。
+func (s *ShareManager) removeLocked(link *ShareLink) {
+	delete(s.links, link.token)
+	delete(s.byID, link.ID)
+	close(link.done)
+}
+
+// randomToken 生成指定字节数的随机 URL 安全字符串。
+func randomToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HandleCreateShare 为会话生成可过期的分享链接。
+func HandleCreateShare(manager *SessionManager, shares *ShareManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var payload CreateShareRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if payload.SessionID == "" {
+			writeError(w, http.StatusBadRequest, "session_id required")
+			return
+		}
+		session, ok := authorizeSession(r, manager, payload.SessionID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
+		principal := requestPrincipal(r)
+		if !isSessionOwner(session, principal) {
+			writeError(w, http.StatusForbidden, "only owner can share session")
+			return
+		}
+		ttl := defaultShareTTL
+		if payload.TTLSeconds > 0 {
+			ttl = time.Duration(payload.TTLSeconds) * time.Second
+		}
+		if ttl > maxShareTTL {
+			ttl = maxShareTTL
+		}
+		createdBy := ""
+		if principal != nil {
+			createdBy = principal.Username
+		}
+
+		link, token, err := shares.Create(payload.SessionID, createdBy, ttl, payload.ReadOnly)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "create share failed")
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "session.share", SessionID: payload.SessionID, Success: true, Detail: map[string]any{"share_id": link.ID, "read_only": payload.ReadOnly, "expires_at": link.ExpiresAt}})
+		writeJSON(w, http.StatusOK, map[string]any{
+			"share":  link,
+			"token":  token,
+			"ws_url": buildShareWSURL(r, token),
+		})
+	}
+}
+
+// HandleListShares 返回当前用户可管理的分享链接。
+func HandleListShares(manager *SessionManager, shares *ShareManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		principal := requestPrincipal(r)
+		items := make([]ShareLink, 0)
+		for _, link := range shares.List(r.URL.Query().Get("session_id")) {
+			session, ok := manager.GetSession(link.SessionID)
+			if !ok || !isSessionOwner(session, principal) {
+				continue
+			}
+			items = append(items, link)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"shares": items})
+	}
+}
+
+// HandleRevokeShare 撤销分享链接。
+func HandleRevokeShare(manager *SessionManager, shares *ShareManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var payload RevokeShareRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if payload.ID == "" {
+			writeError(w, http.StatusBadRequest, "id required")
+			return
+		}
+		link, ok := shares.Get(payload.ID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "share not found")
+			return
+		}
+		// 重要逻辑：会话已关闭时只有管理员才能清理遗留链接。
+		principal := requestPrincipal(r)
+		allowed := principal == nil || principal.IsAdmin()
+		if session, ok := manager.GetSession(link.SessionID); ok {
+			allowed = isSessionOwner(session, principal)
+		}
+		if !allowed {
+			writeError(w, http.StatusNotFound, "share not found")
+			return
+		}
+
+		if err := shares.Revoke(payload.ID); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "session.share_revoke", SessionID: link.SessionID, Success: true, Detail: map[string]any{"share_id": payload.ID}})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
+
+// buildShareWSURL 生成分享链接对应的 WebSocket 地址。
+func buildShareWSURL(r *http.Request, token string) string {
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
+
+	return scheme + "://" + r.Host + "/api/ws?share=" + token
+}
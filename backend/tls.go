@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const selfSignedValidity = 825 * 24 * time.Hour
+
+// loadTLSCertificate 加载配置的证书，未配置时生成并复用自签名证书。
+func loadTLSCertificate(cfg Config) (tls.Certificate, error) {
+	certFile, keyFile := cfg.TLSCert, cfg.TLSKey
+	if certFile == "" && keyFile == "" {
+		certFile = filepath.Join(cfg.TLSDir, "self-signed.crt")
+		keyFile = filepath.Join(cfg.TLSDir, "self-signed.key")
+		// 重要逻辑：证书持久化到磁盘，重启后指纹不变，浏览器无需重复信任。
+		if _, err := os.Stat(certFile); errors.Is(err, os.ErrNotExist) {
+			if err := generateSelfSignedCert(certFile, keyFile); err != nil {
+				return tls.Certificate{}, err
+			}
+			log.Printf("generated self-signed certificate: %s", certFile)
+		}
+	} else if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, errors.New("both APP_TLS_CERT and APP_TLS_KEY are required")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if len(cert.Certificate) > 0 {
+		log.Printf("tls certificate sha256 fingerprint: %s", certificateFingerprint(cert.Certificate[0]))
+	}
+	return cert, nil
+}
+
+// generateSelfSignedCert 为本机主机名与网卡地址生成 ECDSA 自签名证书。
+func generateSelfSignedCert(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "anywhere-code", Organization: []string{"Anywhere Code"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           localIPAddresses(),
+	}
+	if hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(certFile), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+// localIPAddresses 返回本机所有网卡地址，保证通过局域网 IP 访问时证书匹配。
+func localIPAddresses() []net.IP {
+	ips := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+	return ips
+}
+
+// certificateFingerprint 计算证书 DER 的 SHA-256 指纹（冒号分隔的十六进制）。
+func certificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// redirectToHTTPS 将 HTTP 请求重定向到 HTTPS 端口。
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := hostWithoutPort(r.Host)
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := "https://" + host
+		if httpsPort != "443" {
+			target += ":" + httpsPort
+		}
+		http.Redirect(w, r, target+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpIssuer        = "Anywhere Code"
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+// TOTPCodeRequest 是提交动态验证码的请求。
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// DisableTOTPRequest 是关闭二次验证的请求。
+type DisableTOTPRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// generateTOTPSecret 生成 160 位随机密钥（Base32 编码）。
+func generateTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf), nil
+}
+
+// totpCode 按 RFC 6238 计算指定时间步的验证码。
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000), nil
+}
+
+// matchTOTP 校验验证码，允许前后各一个时间步的时钟偏差，返回匹配的时间步。
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for offset := int64(-totpSkew); offset <= totpSkew; offset++ {
+		expected, err := totpCode(secret, current+offset)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return current + offset, true
+		}
+	}
+	return 0, false
+}
+
+// buildOTPAuthURI 生成认证器 App 可识别的 otpauth URI（可直接渲染为二维码）。
+func buildOTPAuthURI(username, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// generateRecoveryCodes 生成一次性恢复码，返回明文与哈希。
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(hex.EncodeToString(buf))
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode 计算恢复码哈希；恢复码本身为高熵随机值，使用 SHA-256 即可。
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// TOTPEnabled 判断用户是否开启了二次验证。
+func (s *UserStore) TOTPEnabled(username string) bool {
+	user, ok := s.Get(username)
+	return ok && user.TOTPEnabled
+}
+
+// BeginTOTP 为用户生成待确认的 TOTP 密钥。
+func (s *UserStore) BeginTOTP(username string) (string, error) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return "", errors.New("user not found")
+	}
+	if user.TOTPEnabled {
+		return "", errors.New("totp already enabled")
+	}
+	user.TOTPPending = secret
+	if err := s.saveLocked(); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// ConfirmTOTP 校验首个验证码并启用二次验证，返回恢复码明文。
+func (s *UserStore) ConfirmTOTP(username, code string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	if user.TOTPPending == "" {
+		return nil, errors.New("totp enrollment not started")
+	}
+	step, ok := matchTOTP(user.TOTPPending, code, time.Now())
+	if !ok {
+		return nil, errors.New("invalid code")
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPSecret = user.TOTPPending
+	user.TOTPPending = ""
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodes = hashes
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP 关闭用户的二次验证并清除恢复码。
+func (s *UserStore) DisableTOTP(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return errors.New("user not found")
+	}
+	user.TOTPEnabled = false
+	user.TOTPSecret = ""
+	user.TOTPPending = ""
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	return s.saveLocked()
+}
+
+// VerifySecondFactor 校验动态验证码或恢复码；恢复码使用后立即作废。
+func (s *UserStore) VerifySecondFactor(username, code string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok || !user.TOTPEnabled || code == "" {
+		return false
+	}
+	// 重要逻辑：同一时间步的验证码只能使用一次，防止被截获后重放。
+	if step, ok := matchTOTP(user.TOTPSecret, code, time.Now()); ok && step > user.TOTPLastStep {
+		user.TOTPLastStep = step
+		return s.saveLocked() == nil
+	}
+	hash := hashRecoveryCode(code)
+	for i, stored := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			return s.saveLocked() == nil
+		}
+	}
+	return false
+}
+
+// HandleTOTPEnroll 生成待确认的 TOTP 密钥与 otpauth URI。
+func HandleTOTPEnroll(users *UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		principal := requestPrincipal(r)
+		if principal == nil {
+			writeError(w, http.StatusForbidden, "login required")
+			return
+		}
+		secret, err := users.BeginTOTP(principal.Username)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"secret":      secret,
+			"otpauth_uri": buildOTPAuthURI(principal.Username, secret),
+		})
+	}
+}
+
+// HandleTOTPVerify 校验首个验证码以完成启用，并返回恢复码。
+func HandleTOTPVerify(users *UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		principal := requestPrincipal(r)
+		if principal == nil {
+			writeError(w, http.StatusForbidden, "login required")
+			return
+		}
+
+		var payload TOTPCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		codes, err := users.ConfirmTOTP(principal.Username, payload.Code)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "totp.enable", Success: true})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "recovery_codes": codes})
+	}
+}
+
+// HandleTOTPDisable 在验证密码与验证码后关闭二次验证。
+func HandleTOTPDisable(users *UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		principal := requestPrincipal(r)
+		if principal == nil {
+			writeError(w, http.StatusForbidden, "login required")
+			return
+		}
+
+		var payload DisableTOTPRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if _, ok := users.Authenticate(principal.Username, payload.Password); !ok {
+			writeError(w, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+		if !users.VerifySecondFactor(principal.Username, payload.Code) {
+			writeError(w, http.StatusUnauthorized, "invalid code")
+			return
+		}
+		if err := users.DisableTOTP(principal.Username); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "totp.disable", Success: true})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const maxSearchResults = 500
+
+// ansiEscapePattern 匹配 CSI、OSC 与单字符转义序列。
+var ansiEscapePattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// stripANSI 去除终端控制序列与回车，得到纯文本。
+func stripANSI(data []byte) string {
+	text := ansiEscapePattern.ReplaceAllString(string(data), "")
+	return strings.ReplaceAll(text, "\r", "")
+}
+
+// sessionTranscript 返回脱敏后的会话输出缓存。
+func sessionTranscript(session *Session, redactor *Redactor) []byte {
+	return redactor.Redact(session.Buffer.Snapshot())
+}
+
+// sessionTextTranscript 返回去除控制序列后再脱敏的纯文本会话输出。
+// 重要逻辑：必须先去除控制序列，否则颜色等序列会把敏感信息切开，使脱敏规则匹配不到。
+func sessionTextTranscript(session *Session, redactor *Redactor) string {
+	return string(redactor.Redact([]byte(stripANSI(session.Buffer.Snapshot()))))
+}
+
+// HandleExportSession 导出会话输出缓存，format=text 时去除控制序列。
+func HandleExportSession(manager *SessionManager, redactor *Redactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID := r.URL.Query().Get("session_id")
+		if sessionID == "" {
+			writeError(w, http.StatusBadRequest, "session_id required")
+			return
+		}
+		session, ok := authorizeSession(r, manager, sessionID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
+
+		var data []byte
+		if r.URL.Query().Get("format") == "text" {
+			data = []byte(sessionTextTranscript(session, redactor))
+		} else {
+			data = sessionTranscript(session, redactor)
+		}
+		recordAudit(r, AuditEvent{Action: "session.export", SessionID: sessionID, Success: true, Detail: map[string]any{"size": len(data)}})
+		filename := fmt.Sprintf("%s-%s.log", session.Name, time.Now().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		_, _ = w.Write(data)
+	}
+}
+
+// HandleSearchSession 在会话输出缓存中按行搜索（忽略大小写）。
+func HandleSearchSession(manager *SessionManager, redactor *Redactor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID := r.URL.Query().Get("session_id")
+		query := strings.ToLower(r.URL.Query().Get("q"))
+		if sessionID == "" || query == "" {
+			writeError(w, http.StatusBadRequest, "session_id and q required")
+			return
+		}
+		session, ok := authorizeSession(r, manager, sessionID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "session not found")
+			return
+		}
+
+		// 重要逻辑：先脱敏再搜索，避免通过搜索关键字逐位探测密钥。
+		lines := strings.Split(sessionTextTranscript(session, redactor), "\n")
+		matches := make([]map[string]any, 0)
+		truncated := false
+		for i, line := range lines {
+			if !strings.Contains(strings.ToLower(line), query) {
+				continue
+			}
+			if len(matches) >= maxSearchResults {
+				truncated = true
+				break
+			}
+			matches = append(matches, map[string]any{"line": i + 1, "text": line})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"matches": matches, "truncated": truncated})
+	}
+}
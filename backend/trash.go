@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	trashDirName       = ".anywhere-trash"
+	trashItemName      = "item"
+	trashMetaName      = "meta.json"
+	trashPurgeInterval = time.Hour
+)
+
+// TrashEntry 是回收站中一个条目的元数据，OriginalPath 是相对删除时文件根目录 Root 的路径。
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Root         string    `json:"root,omitempty"`
+	OriginalPath string    `json:"original_path"`
+	DeletedAt    time.Time `json:"deleted_at"`
+	DeletedBy    string    `json:"deleted_by,omitempty"`
+	IsDir        bool      `json:"is_dir"`
+}
+
+// TrashRequest 是恢复或清除回收站条目的请求。
+type TrashRequest struct {
+	ID        string `json:"id"`
+	All       bool   `json:"all"`
+	Overwrite bool   `json:"overwrite"`
+}
+
+var errTrashEntryNotFound = errors.New("trash entry not found")
+
+// trashRootFor 返回 base 对应的回收站所属根目录：优先使用包含它的工作区根目录；
+// 未配置根目录时固定使用会话账号的主目录，回收站位置不随 shell 的 cd 变化。
+func (m *SessionManager) trashRootFor(account *RunAsAccount, base string) string {
+	if root, ok := m.containingWorkspaceRoot(base); ok {
+		if realRoot, err := resolveRealPath(root.Path); err == nil {
+			return realRoot
+		}
+	}
+	trashRoot := base
+	if len(m.roots) == 0 {
+		if home := trashHomeFor(account); home != "" {
+			trashRoot = home
+		}
+	}
+	if realRoot, err := resolveRealPath(trashRoot); err == nil {
+		trashRoot = realRoot
+	}
+	// 重要逻辑：记录用过的回收站位置，定期清理时一并覆盖。
+	m.trashMu.Lock()
+	if m.trashRoots == nil {
+		m.trashRoots = make(map[string]bool)
+	}
+	m.trashRoots[trashRoot] = true
+	m.trashMu.Unlock()
+	return trashRoot
+}
+
+// trashHomeFor 返回账号的主目录，未切换账号时使用服务进程用户的主目录。
+func trashHomeFor(account *RunAsAccount) string {
+	if account != nil {
+		return account.HomeDir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return home
+}
+
+// knownTrashRoots 返回需要定期清理的回收站位置：工作区根目录，未配置根目录时还包括
+// 服务进程用户与各可选运行账号的主目录（重启前创建的回收站也能被清理），以及运行中用过的位置。
+func (m *SessionManager) knownTrashRoots() []string {
+	seen := make(map[string]bool)
+	var roots []string
+	add := func(path string) {
+		if path == "" {
+			return
+		}
+		if realPath, err := resolveRealPath(path); err == nil {
+			path = realPath
+		}
+		if !seen[path] {
+			seen[path] = true
+			roots = append(roots, path)
+		}
+	}
+	for _, root := range m.WorkspaceRoots() {
+		add(root.Path)
+	}
+	if len(m.roots) == 0 {
+		add(trashHomeFor(nil))
+		for _, account := range m.runAsAccounts() {
+			add(account.HomeDir)
+		}
+	}
+	m.trashMu.Lock()
+	for path := range m.trashRoots {
+		add(path)
+	}
+	m.trashMu.Unlock()
+	return roots
+}
+
+// ensureTrashDir 创建回收站目录，并写入 .gitignore 避免出现在 git status 中。
+func ensureTrashDir(trashRoot string) (string, error) {
+	dir := filepath.Join(trashRoot, trashDirName)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); errors.Is(err, fs.ErrNotExist) {
+		_ = os.WriteFile(ignore, []byte("*\n"), 0o644)
+	}
+	return dir, nil
+}
+
+// moveToTrash 将文件根目录 root 下的 target 移入回收站并记录元数据。
+func moveToTrash(trashRoot, root, target, user string, isDir bool) (TrashEntry, error) {
+	// 重要逻辑：回收站固定在主目录时，目标可能位于其外，原路径统一记录为相对文件根目录的路径。
+	realRoot, err := resolveRealPath(root)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	original, err := filepath.Rel(realRoot, target)
+	if err != nil || !pathWithin(realRoot, target) {
+		return TrashEntry{}, errPathEscape
+	}
+	dir, err := ensureTrashDir(trashRoot)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	entry := TrashEntry{
+		ID:           uuid.NewString(),
+		Name:         filepath.Base(target),
+		Root:         realRoot,
+		OriginalPath: filepath.ToSlash(original),
+		DeletedAt:    time.Now(),
+		DeletedBy:    user,
+		IsDir:        isDir,
+	}
+	entryDir := filepath.Join(dir, entry.ID)
+	if err := os.Mkdir(entryDir, 0o700); err != nil {
+		return TrashEntry{}, err
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, trashMetaName), data, 0o600); err != nil {
+		_ = os.RemoveAll(entryDir)
+		return TrashEntry{}, err
+	}
+	if err := moveEntry(target, filepath.Join(entryDir, trashItemName)); err != nil {
+		_ = os.RemoveAll(entryDir)
+		return TrashEntry{}, err
+	}
+	return entry, nil
+}
+
+// moveEntry 移动文件或目录，跨文件系统时退化为复制后删除。
+func moveEntry(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyTree(src, dst); err != nil {
+		_ = os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// listTrash 返回回收站条目（最近删除的在前），并清除超过保留期的条目。
+func listTrash(trashRoot string, retention time.Duration) ([]TrashEntry, error) {
+	dir := filepath.Join(trashRoot, trashDirName)
+	items, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []TrashEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]TrashEntry, 0, len(items))
+	for _, item := range items {
+		if !item.IsDir() {
+			continue
+		}
+		entry, err := readTrashEntry(trashRoot, item.Name())
+		if err != nil {
+			continue
+		}
+		// 重要逻辑：访问回收站时顺带清理过期条目，未配置工作区根目录时也能自动清理。
+		if retention > 0 && time.Since(entry.DeletedAt) > retention {
+			_ = os.RemoveAll(filepath.Join(dir, entry.ID))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// readTrashEntry 读取回收站条目的元数据。
+func readTrashEntry(trashRoot, id string) (TrashEntry, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return TrashEntry{}, errTrashEntryNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(trashRoot, trashDirName, id, trashMetaName))
+	if err != nil {
+		return TrashEntry{}, errTrashEntryNotFound
+	}
+	var entry TrashEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.ID != id {
+		return TrashEntry{}, errTrashEntryNotFound
+	}
+	return entry, nil
+}
+
+// purgeExpiredTrash 定期清理所有回收站位置中过期的条目。
+func (m *SessionManager) purgeExpiredTrash() {
+	if m.trashRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, trashRoot := range m.knownTrashRoots() {
+			if _, err := listTrash(trashRoot, m.trashRetention); err != nil {
+				log.Printf("purge trash failed: %s: %v", trashRoot, err)
+			}
+		}
+	}
+}
+
+// beginTrashOp 解析回收站请求的文件根目录及其回收站所属的根目录。
+func beginTrashOp(w http.ResponseWriter, r *http.Request, manager *SessionManager) (string, string, string, bool) {
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		writeError(w, http.StatusBadRequest, "session_id required")
+		return "", "", "", false
+	}
+	root, _, err := resolveFileRoot(r, manager, sessionID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return "", "", "", false
+	}
+	return sessionID, root, manager.trashRootFor(requestAccount(r), root), true
+}
+
+// trashRestoreRoot 返回条目恢复时使用的根目录。元数据可能被 shell 内修改，
+// 原路径必须是相对路径，记录的根目录必须位于回收站所属根目录或当前文件根目录之内；
+// 旧条目未记录时使用回收站所属根目录。
+func trashRestoreRoot(entry TrashEntry, root, trashRoot string) (string, error) {
+	if filepath.IsAbs(filepath.FromSlash(entry.OriginalPath)) {
+		return "", errPathEscape
+	}
+	if entry.Root == "" {
+		return trashRoot, nil
+	}
+	if !filepath.IsAbs(entry.Root) {
+		return "", errPathEscape
+	}
+	// 重要逻辑：按真实路径比较，防止记录的根目录经符号链接指向范围之外。
+	restoreRoot, err := resolveRealPath(entry.Root)
+	if err != nil {
+		return "", err
+	}
+	if pathWithin(trashRoot, restoreRoot) {
+		return restoreRoot, nil
+	}
+	if realRoot, err := resolveRealPath(root); err == nil && pathWithin(realRoot, restoreRoot) {
+		return restoreRoot, nil
+	}
+	return "", errPathEscape
+}
+
+// HandleListTrash 返回回收站条目。
+func HandleListTrash(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		_, _, trashRoot, ok := beginTrashOp(w, r, manager)
+		if !ok {
+			return
+		}
+		entries, err := listTrash(trashRoot, manager.trashRetention)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"root": trashRoot, "entries": entries})
+	}
+}
+
+// HandleRestoreTrash 将回收站条目恢复到原位置。
+func HandleRestoreTrash(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID, root, trashRoot, ok := beginTrashOp(w, r, manager)
+		if !ok {
+			return
+		}
+		var payload TrashRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		entry, err := readTrashEntry(trashRoot, payload.ID)
+		if err != nil {
+			writeErrorCode(w, http.StatusNotFound, "not_found", err.Error())
+			return
+		}
+		// 重要逻辑：恢复目标按记录的根目录解析并做越界校验。
+		restoreRoot, err := trashRestoreRoot(entry, root, trashRoot)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		target, err := resolveSafeEntry(restoreRoot, filepath.FromSlash(entry.OriginalPath), manager.followSymlinks)
+		if err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		entryDir := filepath.Join(trashRoot, trashDirName, entry.ID)
+		item := filepath.Join(entryDir, trashItemName)
+		if pathWithin(target, item) {
+			writeFileOpError(w, errTargetIsParent)
+			return
+		}
+		// 重要逻辑：覆盖恢复时，已存在的目标同样移入回收站而不是直接删除。
+		if _, err := prepareTransferTarget(manager, r, restoreRoot, item, target, payload.Overwrite); err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		if err := moveEntry(item, target); err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		_ = os.RemoveAll(entryDir)
+		recordAudit(r, AuditEvent{Action: "file.trash_restore", SessionID: sessionID, Path: target, Success: true, Detail: map[string]any{"trash_id": entry.ID}})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "path": entry.OriginalPath})
+	}
+}
+
+// HandlePurgeTrash 永久删除回收站条目，all 为 true 时清空回收站。
+func HandlePurgeTrash(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		sessionID, _, trashRoot, ok := beginTrashOp(w, r, manager)
+		if !ok {
+			return
+		}
+		var payload TrashRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		ids := []string{payload.ID}
+		if payload.All {
+			entries, err := listTrash(trashRoot, manager.trashRetention)
+			if err != nil {
+				writeFileOpError(w, err)
+				return
+			}
+			ids = ids[:0]
+			for _, entry := range entries {
+				ids = append(ids, entry.ID)
+			}
+		}
+		purged := 0
+		for _, id := range ids {
+			if _, err := readTrashEntry(trashRoot, id); err != nil {
+				if payload.All {
+					continue
+				}
+				writeErrorCode(w, http.StatusNotFound, "not_found", err.Error())
+				return
+			}
+			if err := os.RemoveAll(filepath.Join(trashRoot, trashDirName, id)); err != nil {
+				writeFileOpError(w, err)
+				return
+			}
+			purged++
+		}
+		recordAudit(r, AuditEvent{Action: "file.trash_purge", SessionID: sessionID, Path: trashRoot, Success: true, Detail: map[string]any{"ids": ids}})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "purged": purged})
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	maxExtractSize    = 10 * maxUploadSize
+	maxExtractEntries = 10000
+)
+
+// 上传目标已存在时的处理策略。
+const (
+	conflictOverwrite = "overwrite"
+	conflictSkip      = "skip"
+	conflictRename    = "rename"
+	conflictFail      = "fail"
+)
+
+var (
+	errArchiveTooLarge = errors.New("archive exceeds extract limit")
+	errUploadConflict  = errors.New("file already exists")
+)
+
+// UploadResult 是单个上传文件的处理结果，Path 相对根目录。
+type UploadResult struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Action string `json:"action"`
+}
+
+// uploadTarget 描述上传内容写入的位置，所有路径都相对 base 并限制在 root 内。
+type uploadTarget struct {
+	root           string
+	base           string
+	followSymlinks bool
+}
+
+// uploadRelativePath 返回上传文件携带的相对路径；目录上传时浏览器会发送 dir/sub/file 形式的文件名，
+// 而 multipart 解析后的 Filename 只保留了最后一段，因此直接读取原始的 Content-Disposition。
+func uploadRelativePath(header *multipart.FileHeader) string {
+	name := header.Filename
+	if _, params, err := mime.ParseMediaType(header.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = params["filename"]
+	}
+	return filepath.FromSlash(strings.ReplaceAll(name, "\\", "/"))
+}
+
+// uploadArchiveFormat 根据文件名判断是否为支持解压的压缩包。
+func uploadArchiveFormat(name string) (string, bool) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", true
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz", true
+	default:
+		return "", false
+	}
+}
+
+// parseConflictPolicy 解析 conflict 参数，缺省为覆盖。
+func parseConflictPolicy(value string) (string, bool) {
+	switch value {
+	case "":
+		return conflictOverwrite, true
+	case conflictOverwrite, conflictSkip, conflictRename, conflictFail:
+		return value, true
+	default:
+		return "", false
+	}
+}
+
+// resolveConflict 按策略处理目标已存在的情况，返回实际写入路径与执行的动作。
+func resolveConflict(dest, policy string) (string, string, error) {
+	info, err := os.Lstat(dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return dest, "created", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	switch policy {
+	case conflictSkip:
+		return dest, "skipped", nil
+	case conflictRename:
+		renamed, err := availableName(dest)
+		return renamed, "renamed", err
+	case conflictOverwrite:
+		// 重要逻辑：只覆盖普通文件，同名目录或特殊文件按冲突处理。
+		if info.Mode().IsRegular() {
+			return dest, "overwritten", nil
+		}
+	}
+	return "", "", fmt.Errorf("%w: %s", errUploadConflict, filepath.Base(dest))
+}
+
+// availableName 返回 name (1).ext 形式的未被占用的文件名。
+func availableName(dest string) (string, error) {
+	dir, base := filepath.Split(dest)
+	ext := filepath.Ext(base)
+	if strings.HasSuffix(strings.ToLower(base), ".tar.gz") {
+		ext = base[len(base)-len(".tar.gz"):]
+	}
+	stem := strings.TrimSuffix(base, ext)
+	for i := 1; i <= 1000; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+		if _, err := os.Lstat(candidate); errors.Is(err, fs.ErrNotExist) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%w: %s", errUploadConflict, base)
+}
+
+// writeUploadFile 先写入同目录的临时文件，成功后再重命名到目标路径，失败时不留下残缺文件。
+func writeUploadFile(dest string, src io.Reader, perm os.FileMode) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	tmpName := tmp.Name()
+	written, err := io.Copy(tmp, src)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, dest)
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return 0, err
+	}
+	return written, nil
+}
+
+// uploadFileMode 返回写入的权限，覆盖时沿用原文件权限。
+func uploadFileMode(dest, action string) os.FileMode {
+	if action == "overwritten" {
+		if info, err := os.Stat(dest); err == nil {
+			return info.Mode().Perm()
+		}
+	}
+	return 0o644
+}
+
+// resolve 解析相对路径对应的目标文件，越界路径返回 errPathEscape。
+func (t uploadTarget) resolve(rel string) (string, error) {
+	clean := filepath.Clean(rel)
+	if filepath.IsAbs(clean) {
+		return "", errPathEscape
+	}
+	if clean == "." {
+		return "", errors.New("invalid file name")
+	}
+	return resolveSafePath(t.root, filepath.Join(t.base, clean), t.followSymlinks)
+}
+
+// save 按冲突策略将内容写入相对路径，自动创建中间目录。
+func (t uploadTarget) save(rel string, src io.Reader, policy string) (UploadResult, error) {
+	dest, err := t.resolve(rel)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return UploadResult{}, err
+	}
+	final, action, err := resolveConflict(dest, policy)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	// 重要逻辑：返回的路径相对根目录，重命名时使用新文件名，可直接用于其他文件接口。
+	result := UploadResult{
+		Path:   filepath.ToSlash(filepath.Join(t.base, filepath.Dir(filepath.Clean(rel)), filepath.Base(final))),
+		Action: action,
+	}
+	if action == "skipped" {
+		if info, err := os.Stat(final); err == nil {
+			result.Size = info.Size()
+		}
+		return result, nil
+	}
+	result.Size, err = writeUploadFile(final, src, uploadFileMode(final, action))
+	if err != nil {
+		return UploadResult{}, err
+	}
+	return result, nil
+}
+
+// mkdir 创建压缩包中的目录项。
+func (t uploadTarget) mkdir(rel string) error {
+	dest, err := t.resolve(rel)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(dest, 0o755)
+}
+
+// extractBudget 限制解压的总大小与条目数，防止压缩炸弹占满磁盘。
+type extractBudget struct {
+	remaining int64
+	entries   int
+}
+
+// take 返回受剩余额度限制的 reader，并计入条目数。
+func (b *extractBudget) take(src io.Reader) (io.Reader, error) {
+	b.entries++
+	if b.entries > maxExtractEntries {
+		return nil, errArchiveTooLarge
+	}
+	return &budgetReader{budget: b, src: src}, nil
+}
+
+// budgetReader 在读取时扣减解压额度，超出时报错。
+type budgetReader struct {
+	budget *extractBudget
+	src    io.Reader
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	r.budget.remaining -= int64(n)
+	if r.budget.remaining < 0 {
+		return n, errArchiveTooLarge
+	}
+	return n, err
+}
+
+// saveUploadPart 保存表单中的一个上传文件，extract 为 true 且文件是压缩包时解压到目标目录。
+func saveUploadPart(target uploadTarget, header *multipart.FileHeader, src multipart.File, extract bool, conflict string) ([]UploadResult, error) {
+	// 重要逻辑：保留目录上传时的相对路径，中间目录按需创建。
+	rel := uploadRelativePath(header)
+	if format, ok := uploadArchiveFormat(rel); extract && ok {
+		if format == "zip" {
+			return extractZip(target, src, header.Size, conflict)
+		}
+		return extractTarGz(target, src, conflict)
+	}
+	result, err := target.save(rel, src, conflict)
+	if err != nil {
+		return nil, err
+	}
+	return []UploadResult{result}, nil
+}
+
+// extractZip 解压 zip 到目标目录，返回每个文件的处理结果。
+func extractZip(target uploadTarget, file io.ReaderAt, size int64, policy string) ([]UploadResult, error) {
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, err
+	}
+	budget := &extractBudget{remaining: maxExtractSize}
+	files := make([]UploadResult, 0, len(archive.File))
+	for _, entry := range archive.File {
+		mode := entry.Mode()
+		// 重要逻辑：条目名中的 ../ 与绝对路径在 resolve 中被拦截（zip-slip）。
+		if mode.IsDir() {
+			if err := target.mkdir(entry.Name); err != nil {
+				return files, err
+			}
+			continue
+		}
+		// 重要逻辑：符号链接等特殊条目不解压，避免借助链接写到根目录之外。
+		if !mode.IsRegular() {
+			continue
+		}
+		src, err := entry.Open()
+		if err != nil {
+			return files, err
+		}
+		limited, err := budget.take(src)
+		if err != nil {
+			_ = src.Close()
+			return files, err
+		}
+		result, err := target.save(entry.Name, limited, policy)
+		_ = src.Close()
+		if err != nil {
+			return files, err
+		}
+		files = append(files, result)
+	}
+	return files, nil
+}
+
+// extractTarGz 解压 tar.gz 到目标目录，返回每个文件的处理结果。
+func extractTarGz(target uploadTarget, src io.Reader, policy string) ([]UploadResult, error) {
+	decompressor, err := gzip.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+	archive := tar.NewReader(decompressor)
+	budget := &extractBudget{remaining: maxExtractSize}
+	files := make([]UploadResult, 0)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := target.mkdir(header.Name); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			limited, err := budget.take(archive)
+			if err != nil {
+				return files, err
+			}
+			result, err := target.save(header.Name, limited, policy)
+			if err != nil {
+				return files, err
+			}
+			files = append(files, result)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveEntry 是测试压缩包中的一个条目，link 非空时为指向 link 的符号链接。
+type archiveEntry struct {
+	name string
+	body string
+	link string
+}
+
+// buildZip 按条目生成 zip 压缩包。
+func buildZip(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		body := entry.body
+		if entry.link != "" {
+			header.SetMode(os.ModeSymlink | 0o777)
+			body = entry.link
+		} else {
+			header.SetMode(0o644)
+		}
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// buildTarGz 按条目生成 tar.gz 压缩包。
+func buildTarGz(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	compressor := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(compressor)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(entry.body))}
+		if entry.link != "" {
+			header = &tar.Header{Name: entry.name, Mode: 0o777, Typeflag: tar.TypeSymlink, Linkname: entry.link}
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(writer, entry.body); err != nil && entry.link == "" {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extractArchive 以 format 指定的格式解压到 target。
+func extractArchive(target uploadTarget, format string, data []byte) ([]UploadResult, error) {
+	if format == "zip" {
+		return extractZip(target, bytes.NewReader(data), int64(len(data)), conflictOverwrite)
+	}
+	return extractTarGz(target, bytes.NewReader(data), conflictOverwrite)
+}
+
+// TestUploadTargetResolve 确认上传路径中的 ../ 与绝对路径被拒绝，base 内的相对路径正常解析。
+func TestUploadTargetResolve(t *testing.T) {
+	root, outside := newEscapeFixture(t)
+	cases := []struct {
+		base string
+		rel  string
+		want string
+		err  error
+	}{
+		{rel: "a.txt", want: filepath.Join(root, "a.txt")},
+		{base: "sub", rel: "dir/a.txt", want: filepath.Join(root, "sub", "dir", "a.txt")},
+		{rel: "../a.txt", err: errPathEscape},
+		{rel: "dir/../../a.txt", err: errPathEscape},
+		{base: "sub", rel: "../../a.txt", err: errPathEscape},
+		{rel: filepath.Join(outside, "a.txt"), err: errPathEscape},
+		{rel: "link/a.txt", err: errPathEscape},
+		{base: "link", rel: "a.txt", err: errPathEscape},
+	}
+	for _, tc := range cases {
+		target := uploadTarget{root: root, base: tc.base}
+		got, err := target.resolve(filepath.FromSlash(tc.rel))
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("resolve(%q, %q) error = %v, want %v", tc.base, tc.rel, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Fatalf("resolve(%q, %q) = %q, %v, want %q", tc.base, tc.rel, got, err, tc.want)
+		}
+	}
+	if _, err := (uploadTarget{root: root}).resolve("."); err == nil {
+		t.Fatalf("resolve(.) accepted")
+	}
+}
+
+// TestExtractArchiveRejectsSlip 确认 zip 与 tar.gz 中的 ../ 与绝对路径条目被拒绝，不会写到根目录之外。
+func TestExtractArchiveRejectsSlip(t *testing.T) {
+	for _, format := range []string{"zip", "tar.gz"} {
+		for _, name := range []string{"../escape.txt", "sub/../../escape.txt", "/tmp/escape.txt", "link/escape.txt"} {
+			root, outside := newEscapeFixture(t)
+			if strings.HasPrefix(name, "/") {
+				name = filepath.ToSlash(filepath.Join(outside, "escape.txt"))
+			}
+			entries := []archiveEntry{{name: name, body: "x"}}
+			var data []byte
+			if format == "zip" {
+				data = buildZip(t, entries)
+			} else {
+				data = buildTarGz(t, entries)
+			}
+			_, err := extractArchive(uploadTarget{root: root}, format, data)
+			// 重要逻辑：新版本的 archive/zip 可能在打开阶段就拒绝不安全的条目名，只要求解压失败。
+			if err == nil || format == "tar.gz" && !errors.Is(err, errPathEscape) {
+				t.Fatalf("%s entry %q: error = %v, want %v", format, name, err, errPathEscape)
+			}
+			if _, err := os.Stat(filepath.Join(outside, "escape.txt")); !os.IsNotExist(err) {
+				t.Fatalf("%s entry %q written outside root", format, name)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escape.txt")); !os.IsNotExist(err) {
+				t.Fatalf("%s entry %q written outside root", format, name)
+			}
+		}
+	}
+}
+
+// TestExtractArchiveSkipsSymlinks 确认压缩包中的符号链接条目不会被创建，后续条目也无法借助它写到根目录之外。
+func TestExtractArchiveSkipsSymlinks(t *testing.T) {
+	for _, format := range []string{"zip", "tar.gz"} {
+		root, outside := newEscapeFixture(t)
+		entries := []archiveEntry{
+			{name: "evil", link: outside},
+			{name: "evil/escape.txt", body: "x"},
+			{name: "ok.txt", body: "ok"},
+		}
+		var data []byte
+		if format == "zip" {
+			data = buildZip(t, entries)
+		} else {
+			data = buildTarGz(t, entries)
+		}
+		files, err := extractArchive(uploadTarget{root: root}, format, data)
+		if err != nil {
+			t.Fatalf("%s: extract error = %v", format, err)
+		}
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+		if want := "evil/escape.txt,ok.txt"; strings.Join(paths, ",") != want {
+			t.Fatalf("%s: files = %v, want %s", format, paths, want)
+		}
+		info, err := os.Lstat(filepath.Join(root, "evil"))
+		if err != nil || !info.IsDir() {
+			t.Fatalf("%s: evil = %v, %v, want plain directory", format, info, err)
+		}
+		if _, err := os.Stat(filepath.Join(outside, "escape.txt")); !os.IsNotExist(err) {
+			t.Fatalf("%s: entry written through symlink", format)
+		}
+	}
+}
+
+// TestExtractBudget 确认解压额度按条目数与总字节数扣减，超出时返回 errArchiveTooLarge。
+func TestExtractBudget(t *testing.T) {
+	budget := &extractBudget{remaining: 10}
+	for i := 0; i < maxExtractEntries; i++ {
+		if _, err := budget.take(strings.NewReader("")); err != nil {
+			t.Fatalf("entry %d: error = %v", i+1, err)
+		}
+	}
+	if _, err := budget.take(strings.NewReader("")); !errors.Is(err, errArchiveTooLarge) {
+		t.Fatalf("entry over limit: error = %v, want %v", err, errArchiveTooLarge)
+	}
+
+	budget = &extractBudget{remaining: 10}
+	reader, err := budget.take(strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatalf("read within budget: error = %v", err)
+	}
+	reader, err = budget.take(strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(reader); !errors.Is(err, errArchiveTooLarge) {
+		t.Fatalf("read over budget: error = %v, want %v", err, errArchiveTooLarge)
+	}
+}
+
+// TestExtractZipStopsAtBudget 确认解压超过额度时中止并返回 errArchiveTooLarge。
+func TestExtractZipStopsAtBudget(t *testing.T) {
+	root, _ := newEscapeFixture(t)
+	data := buildZip(t, []archiveEntry{{name: "big.bin", body: strings.Repeat("0", 1<<20)}})
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget := &extractBudget{remaining: 1 << 10}
+	src, err := archive.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	limited, err := budget.take(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (uploadTarget{root: root}).save("big.bin", limited, conflictOverwrite); !errors.Is(err, errArchiveTooLarge) {
+		t.Fatalf("save error = %v, want %v", err, errArchiveTooLarge)
+	}
+	if _, err := os.Stat(filepath.Join(root, "big.bin")); !os.IsNotExist(err) {
+		t.Fatalf("partial file left after exceeding budget")
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	roleAdmin = "admin"
+	roleUser  = "user"
+
+	passwordHashIterations = 120000
+)
+
+// dummyPasswordHash 在用户不存在时参与校验，迭代次数与真实哈希一致，使耗时无法区分用户是否存在。
+var dummyPasswordHash = fmt.Sprintf("pbkdf2-sha256$%d$AAAAAAAAAAAAAAAAAAAAAA$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", passwordHashIterations)
+
+// User 是持久化的用户账号。
+type User struct {
+	Username      string    `json:"username"`
+	PasswordHash  string    `json:"password_hash"`
+	Role          string    `json:"role"`
+	CreatedAt     time.Time `json:"created_at"`
+	TOTPEnabled   bool      `json:"totp_enabled,omitempty"`
+	TOTPSecret    string    `json:"totp_secret,omitempty"`
+	TOTPPending   string    `json:"totp_pending,omitempty"`
+	TOTPLastStep  int64     `json:"totp_last_step,omitempty"`
+	RecoveryCodes []string  `json:"recovery_codes,omitempty"`
+	RunAs         []string  `json:"run_as,omitempty"`
+}
+
+// UserInfo 是对外返回的用户信息（不含密码哈希）。
+type UserInfo struct {
+	Username    string    `json:"username"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+	TOTPEnabled bool      `json:"totp_enabled"`
+	RunAs       []string  `json:"run_as,omitempty"`
+}
+
+// Principal 是当前请求的身份。
+type Principal struct {
+	Username string
+	Role     string
+	RunAs    []string
+}
+
+// IsAdmin 判断是否为管理员。
+func (p *Principal) IsAdmin() bool {
+	return p != nil && p.Role == roleAdmin
+}
+
+// MayRunAs 判断当前身份能否以指定账号运行会话：管理员与未启用认证时不额外限制，普通用户只能使用分配给自己的账号。
+func (p *Principal) MayRunAs(account string) bool {
+	if p == nil || p.IsAdmin() {
+		return true
+	}
+	for _, allowed := range p.RunAs {
+		if allowed == account {
+			return true
+		}
+	}
+	return false
+}
+
+// UserStore 以 JSON 文件保存用户账号。
+type UserStore struct {
+	path  string
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// userFile 是用户文件的结构。
+type userFile struct {
+	Users []*User `json:"users"`
+}
+
+// CreateUserRequest 是创建用户的请求。
+type CreateUserRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Role     string   `json:"role"`
+	RunAs    []string `json:"run_as"`
+}
+
+// DeleteUserRequest 是删除用户的请求。
+type DeleteUserRequest struct {
+	Username string `json:"username"`
+}
+
+// ChangePasswordRequest 是修改密码的请求。
+type ChangePasswordRequest struct {
+	Username    string `json:"username"`
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// LoadUserStore 从文件加载用户，文件不存在时返回空仓库。
+func LoadUserStore(path string) (*UserStore, error) {
+	store := &UserStore{path: path, users: make(map[string]*User)}
+	if path == "" {
+		return store, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file userFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse users file failed: %w", err)
+	}
+	for _, user := range file.Users {
+		if user != nil && user.Username != "" {
+			store.users[user.Username] = user
+		}
+	}
+	return store, nil
+}
+
+// Empty 判断是否尚未配置任何用户。
+func (s *UserStore) Empty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users) == 0
+}
+
+// Get 返回指定用户。
+func (s *UserStore) Get(username string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[username]
+	if !ok {
+		return nil, false
+	}
+	copied := *user
+	return &copied, true
+}
+
+// Authenticate 校验用户名与密码。
+func (s *UserStore) Authenticate(username, password string) (*User, bool) {
+	user, ok := s.Get(username)
+	if !ok {
+		// 重要逻辑：用户不存在时同样计算一次哈希，避免通过耗时判断用户名是否存在。
+		_ = verifyPasswordHash(dummyPasswordHash, password)
+		return nil, false
+	}
+	if !verifyPasswordHash(user.PasswordHash, password) {
+		return nil, false
+	}
+	return user, true
+}
+
+// List 返回按用户名排序的用户列表。
+func (s *UserStore) List() []UserInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]UserInfo, 0, len(s.users))
+	for _, user := range s.users {
+		result = append(result, UserInfo{
+			Username:    user.Username,
+			Role:        user.Role,
+			CreatedAt:   user.CreatedAt,
+			TOTPEnabled: user.TOTPEnabled,
+			RunAs:       user.RunAs,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Username < result[j].Username
+	})
+	return result
+}
+
+// Create 新建用户并写回文件。
+func (s *UserStore) Create(username, password, role string, runAs []string) error {
+	if s.path == "" {
+		return errors.New("users file not configured")
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[username]; ok {
+		return errors.New("user already exists")
+	}
+	s.users[username] = &User{Username: username, PasswordHash: hash, Role: role, CreatedAt: time.Now(), RunAs: runAs}
+	return s.saveLocked()
+}
+
+// Delete 删除用户并写回文件。
+func (s *UserStore) Delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[username]; !ok {
+		return errors.New("user not found")
+	}
+	delete(s.users, username)
+	return s.saveLocked()
+}
+
+// SetPassword 更新用户密码并写回文件。
+func (s *UserStore) SetPassword(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return errors.New("user not found")
+	}
+	user.PasswordHash = hash
+	return s.saveLocked()
+}
+
+// saveLocked 原子写入用户文件（需要在写锁内调用）。
+func (s *UserStore) saveLocked() error {
+	file := userFile{Users: make([]*User, 0, len(s.users))}
+	for _, user := range s.users {
+		file.Users = append(file.Users, user)
+	}
+	sort.Slice(file.Users, func(i, j int) bool {
+		return file.Users[i].Username < file.Users[j].Username
+	})
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0o600)
+}
+
+// writeFileAtomic 先写临时文件再重命名，避免写入中断导致文件损坏。
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
+// hashPassword 使用 PBKDF2-HMAC-SHA256 生成带盐密码哈希。
+func hashPassword(password string) (string, error) {
+	if len(password) < 8 {
+		return "", errors.New("password too short")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, passwordHashIterations, 32)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s",
+		passwordHashIterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPasswordHash 校验密码是否与哈希匹配。
+func verifyPasswordHash(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	actual := pbkdf2SHA256([]byte(password), salt, iterations, len(expected))
+	return subtle.ConstantTimeCompare(actual, expected) == 1
+}
+
+// pbkdf2SHA256 实现 RFC 8018 中的 PBKDF2（PRF 为 HMAC-SHA256）。
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	result := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u = prf.Sum(u[:0])
+		t := make([]byte, hashLen)
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		result = append(result, t...)
+	}
+	return result[:keyLen]
+}
+
+// HandleListUsers 返回所有用户（仅管理员）。
+func HandleListUsers(users *UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !requestPrincipal(r).IsAdmin() {
+			writeError(w, http.StatusForbidden, "admin required")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"users": users.List()})
+	}
+}
+
+// HandleCreateUser 创建用户（仅管理员）。
+func HandleCreateUser(users *UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !requestPrincipal(r).IsAdmin() {
+			writeError(w, http.StatusForbidden, "admin required")
+			return
+		}
+
+		var payload CreateUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if payload.Username == "" || payload.Password == "" {
+			writeError(w, http.StatusBadRequest, "username and password required")
+			return
+		}
+		if payload.Role == "" {
+			payload.Role = roleUser
+		}
+		if payload.Role != roleUser && payload.Role != roleAdmin {
+			writeError(w, http.StatusBadRequest, "invalid role")
+			return
+		}
+		if err := users.Create(payload.Username, payload.Password, payload.Role, payload.RunAs); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "user.create", Success: true, Detail: map[string]any{"username": payload.Username, "role": payload.Role, "run_as": payload.RunAs}})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
+
+// HandleDeleteUser 删除用户（仅管理员）。
+func HandleDeleteUser(users *UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		principal := requestPrincipal(r)
+		if !principal.IsAdmin() {
+			writeError(w, http.StatusForbidden, "admin required")
+			return
+		}
+
+		var payload DeleteUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if payload.Username == "" {
+			writeError(w, http.StatusBadRequest, "username required")
+			return
+		}
+		if payload.Username == principal.Username {
+			writeError(w, http.StatusBadRequest, "cannot delete yourself")
+			return
+		}
+		if err := users.Delete(payload.Username); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "user.delete", Success: true, Detail: map[string]any{"username": payload.Username}})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
+
+// HandleChangePassword 修改自己的密码，管理员可重置他人密码。
+func HandleChangePassword(users *UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		principal := requestPrincipal(r)
+		if principal == nil {
+			writeError(w, http.StatusForbidden, "login required")
+			return
+		}
+
+		var payload ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if payload.Username == "" {
+			payload.Username = principal.Username
+		}
+		if payload.NewPassword == "" {
+			writeError(w, http.StatusBadRequest, "new_password required")
+			return
+		}
+		if payload.Username != principal.Username && !principal.IsAdmin() {
+			writeError(w, http.StatusForbidden, "admin required")
+			return
+		}
+		// 重要逻辑：修改自己的密码需要验证旧密码，防止会话被盗用后改密。
+		if payload.Username == principal.Username {
+			if _, ok := users.Authenticate(payload.Username, payload.OldPassword); !ok {
+				writeError(w, http.StatusUnauthorized, "invalid credentials")
+				return
+			}
+		}
+		if err := users.SetPassword(payload.Username, payload.NewPassword); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		recordAudit(r, AuditEvent{Action: "user.password", Success: true, Detail: map[string]any{"username": payload.Username}})
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+	}
+}
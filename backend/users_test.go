@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// TestDummyPasswordHashMatchesRealCost 确认不存在用户时的哈希计算与真实哈希耗时一致。
+func TestDummyPasswordHashMatchesRealCost(t *testing.T) {
+	actual, err := hashPassword("correct horse battery")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	dummyParts := strings.Split(dummyPasswordHash, "$")
+	realParts := strings.Split(actual, "$")
+	if len(dummyParts) != 4 || len(realParts) != 4 {
+		t.Fatalf("unexpected hash format: %q %q", dummyPasswordHash, actual)
+	}
+	if dummyParts[1] != realParts[1] {
+		t.Fatalf("dummy iterations = %s, want %s", dummyParts[1], realParts[1])
+	}
+	for i, name := range map[int]string{2: "salt", 3: "key"} {
+		dummy, err := base64.RawStdEncoding.DecodeString(dummyParts[i])
+		if err != nil {
+			t.Fatalf("decode dummy %s: %v", name, err)
+		}
+		want, _ := base64.RawStdEncoding.DecodeString(realParts[i])
+		if len(dummy) != len(want) {
+			t.Fatalf("dummy %s length = %d, want %d", name, len(dummy), len(want))
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// WorkspaceRoot 是允许文件与 Git 接口访问的根目录。
+type WorkspaceRoot struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// loadWorkspaceRoots 解析 name=path 或 path 形式的根目录配置，忽略不存在的目录。
+func loadWorkspaceRoots(specs []string) []WorkspaceRoot {
+	roots := make([]WorkspaceRoot, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		name, path, ok := strings.Cut(spec, "=")
+		if !ok {
+			path = spec
+			name = ""
+		}
+		abs, err := filepath.Abs(strings.TrimSpace(path))
+		if err != nil || !isDirectory(abs) {
+			log.Printf("ignore workspace root %q: not a directory", spec)
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = filepath.Base(abs)
+		}
+		if seen[name] {
+			log.Printf("ignore workspace root %q: duplicate name", spec)
+			continue
+		}
+		seen[name] = true
+		roots = append(roots, WorkspaceRoot{Name: name, Path: abs})
+	}
+	return roots
+}
+
+// WorkspaceRoots 返回配置的根目录列表。
+func (m *SessionManager) WorkspaceRoots() []WorkspaceRoot {
+	return append([]WorkspaceRoot(nil), m.roots...)
+}
+
+// workspaceRoot 按名称查找配置的根目录。
+func (m *SessionManager) workspaceRoot(name string) (WorkspaceRoot, bool) {
+	for _, root := range m.roots {
+		if root.Name == name {
+			return root, true
+		}
+	}
+	return WorkspaceRoot{}, false
+}
+
+// containingWorkspaceRoot 返回包含 path 的根目录。
+func (m *SessionManager) containingWorkspaceRoot(path string) (WorkspaceRoot, bool) {
+	realPath, err := resolveRealPath(path)
+	if err != nil {
+		return WorkspaceRoot{}, false
+	}
+	for _, root := range m.roots {
+		realRoot, err := resolveRealPath(root.Path)
+		if err == nil && pathWithin(realRoot, realPath) {
+			return root, true
+		}
+	}
+	return WorkspaceRoot{}, false
+}
+
+// gitRootAllowed 判断 Git 仓库根目录是否位于配置的根目录内，未配置根目录时不限制。
+func (m *SessionManager) gitRootAllowed(gitRoot string) bool {
+	if len(m.roots) == 0 {
+		return true
+	}
+	_, ok := m.containingWorkspaceRoot(gitRoot)
+	return ok
+}
+
+// resolveFileRoot 确定文件与 Git 接口的根目录，并返回会话当前目录相对根目录的路径作为初始浏览位置。
+// 请求指定 root 时使用对应的配置目录；未指定时使用包含会话当前目录的根目录，
+// 会话目录不在任何根目录内时回退到第一个根目录；未配置根目录时以会话当前目录为根。
+func resolveFileRoot(r *http.Request, manager *SessionManager, sessionID string) (string, string, error) {
+	cwd, err := resolveSessionCWD(r, manager, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	if name := r.URL.Query().Get("root"); name != "" {
+		root, ok := manager.workspaceRoot(name)
+		if !ok {
+			return "", "", errors.New("root not found")
+		}
+		return root.Path, "", nil
+	}
+	if len(manager.roots) == 0 {
+		return cwd, "", nil
+	}
+	// 重要逻辑：根目录固定为工作区根，cd 进入子目录只改变初始浏览位置，文件接口不会随 cd 收窄或带出工作区。
+	if root, ok := manager.containingWorkspaceRoot(cwd); ok {
+		return root.Path, workspaceRelativePath(root.Path, cwd), nil
+	}
+	return manager.roots[0].Path, "", nil
+}
+
+// workspaceRelativePath 返回 path 相对根目录的路径（按真实路径计算），在根目录本身时返回空字符串。
+func workspaceRelativePath(root, path string) string {
+	realRoot, err := resolveRealPath(root)
+	if err != nil {
+		return ""
+	}
+	realPath, err := resolveRealPath(path)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// HandleListRoots 返回可访问的根目录，并给出会话当前目录所在的根目录。
+func HandleListRoots(manager *SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		result := map[string]any{"roots": manager.WorkspaceRoots()}
+		if sessionID := r.URL.Query().Get("session_id"); sessionID != "" {
+			cwd, err := resolveSessionCWD(r, manager, sessionID)
+			if err != nil {
+				writeError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			result["cwd"] = cwd
+			if root, ok := manager.containingWorkspaceRoot(cwd); ok {
+				result["default_root"] = root.Name
+				result["default_path"] = workspaceRelativePath(root.Path, cwd)
+			} else if len(manager.roots) > 0 {
+				result["default_root"] = manager.roots[0].Name
+			}
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
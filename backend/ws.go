@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
-	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/creack/pty"
@@ -19,77 +19,109 @@ type WSMessage struct {
 }
 
 // WebSocketHandler 处理终端连接。
-func WebSocketHandler(manager *SessionManager) http.HandlerFunc {
+func WebSocketHandler(manager *SessionManager, shares *ShareManager, origins *OriginPolicy, redactor *Redactor) http.HandlerFunc {
 	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
+		// 重要逻辑：与 CORS 共用来源白名单，防止任意网页在用户机器上打开 shell。
+		CheckOrigin: origins.Allowed,
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		sessionID := r.URL.Query().Get("session_id")
-		if sessionID == "" {
-			writeError(w, http.StatusBadRequest, "session_id required")
-			return
-		}
-		session, ok := manager.GetSession(sessionID)
-		if !ok {
-			writeError(w, http.StatusNotFound, "session not found")
-			return
+		ctx := r.Context()
+		readOnly := false
+		var session *Session
+		if shareToken := r.URL.Query().Get("share"); shareToken != "" {
+			// 重要逻辑：分享令牌只授权其绑定的会话，忽略请求中的其他 session_id。
+			link, ok := shares.Resolve(shareToken)
+			if !ok {
+				writeError(w, http.StatusUnauthorized, "invalid share token")
+				return
+			}
+			found, ok := manager.GetSession(link.SessionID)
+			if !ok {
+				writeError(w, http.StatusNotFound, "session not found")
+				return
+			}
+			session = found
+			readOnly = link.ReadOnly
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, link.ExpiresAt)
+			defer cancel()
+			go func() {
+				select {
+				case <-link.done:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+		} else {
+			sessionID := r.URL.Query().Get("session_id")
+			if sessionID == "" {
+				writeError(w, http.StatusBadRequest, "session_id required")
+				return
+			}
+			found, ok := authorizeSession(r, manager, sessionID)
+			if !ok {
+				writeError(w, http.StatusNotFound, "session not found")
+				return
+			}
+			session = found
 		}
 
-		conn, err := upgrader.Upgrade(w, r, nil)
+		rawConn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			return
 		}
+		conn := &wsConn{Conn: rawConn}
 		defer conn.Close()
 
-		if err := handleSessionWS(r.Context(), conn, session); err != nil {
+		// 重要逻辑：记录终端连接的接入与断开，断开时附带连接时长。
+		attachedAt := time.Now()
+		recordAudit(r, AuditEvent{Action: "ws.attach", SessionID: session.ID, Success: true, Detail: map[string]any{"read_only": readOnly}})
+		recorder := newInputRecorder(r, session)
+		defer func() {
+			recorder.Flush()
+			recordAudit(r, AuditEvent{Action: "ws.detach", SessionID: session.ID, Success: true, Detail: map[string]any{"duration_seconds": int(time.Since(attachedAt).Seconds())}})
+		}()
+
+		if err := handleSessionWS(ctx, conn, session, readOnly, recorder, redactor); err != nil {
 			_ = conn.WriteJSON(WSMessage{Type: "exit", Data: err.Error()})
 		}
 	}
 }
 
+// wsConn 串行化同一连接上的写操作，gorilla/websocket 不允许并发写。
+type wsConn struct {
+	*websocket.Conn
+	writeMu sync.Mutex
+}
+
+// WriteJSON 加锁后写入 JSON 消息。
+func (c *wsConn) WriteJSON(v any) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.WriteJSON(v)
+}
+
 // handleSessionWS 负责转发 WebSocket 与 PTY 会话数据。
-func handleSessionWS(ctx context.Context, conn *websocket.Conn, session *Session) error {
+func handleSessionWS(ctx context.Context, conn *wsConn, session *Session, readOnly bool, recorder *inputRecorder, redactor *Redactor) error {
 	session.mu.Lock()
 	session.LastActive = time.Now()
 	ptmx := session.PTY
 	session.mu.Unlock()
 
-	outputErr := make(chan error, 1)
 	inputErr := make(chan error, 1)
 
-	// 重连时先回放缓存内容。
-	if cached := session.Buffer.Snapshot(); len(cached) > 0 {
+	// 重要逻辑：PTY 由会话唯一的读取协程读取，这里只订阅其分发的输出；返回即取消订阅。
+	cached, output, unsubscribe := session.Subscribe(redactor)
+	defer unsubscribe()
+
+	// 重连时先回放缓存内容，回放前脱敏，实时输出不做处理。
+	if len(cached) > 0 {
 		if err := conn.WriteJSON(WSMessage{Type: "output", Data: string(cached)}); err != nil {
 			return err
 		}
 	}
 
-	// 读取 PTY 输出并推送给前端。
-	go func() {
-		buffer := make([]byte, 4096)
-		for {
-			n, readErr := ptmx.Read(buffer)
-			if n > 0 {
-				session.Buffer.Write(buffer[:n])
-				if writeErr := conn.WriteJSON(WSMessage{Type: "output", Data: string(buffer[:n])}); writeErr != nil {
-					outputErr <- writeErr
-					return
-				}
-			}
-			if readErr != nil {
-				if readErr == io.EOF {
-					outputErr <- nil
-				} else {
-					outputErr <- readErr
-				}
-				return
-			}
-		}
-	}()
-
 	// 读取 WebSocket 输入并写入 PTY。
 	go func() {
 		for {
@@ -103,11 +135,17 @@ func handleSessionWS(ctx context.Context, conn *websocket.Conn, session *Session
 			session.LastActive = time.Now()
 			session.mu.Unlock()
 
+			// 重要逻辑：只读分享仅允许心跳，输入与尺寸调整都会影响会话。
+			if readOnly && msg.Type != "ping" {
+				continue
+			}
+
 			switch msg.Type {
 			case "input":
 				if msg.Data == "" {
 					continue
 				}
+				recorder.Write(ptmx, []byte(msg.Data))
 				if _, err := ptmx.Write([]byte(msg.Data)); err != nil {
 					inputErr <- err
 					return
@@ -123,12 +161,29 @@ func handleSessionWS(ctx context.Context, conn *websocket.Conn, session *Session
 		}
 	}()
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-outputErr:
-		return err
-	case err := <-inputErr:
-		return err
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-inputErr:
+			return err
+		case data, ok := <-output.ch:
+			if !ok {
+				return output.Err()
+			}
+			if err := conn.WriteJSON(WSMessage{Type: "output", Data: string(data)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// trackSessionCWD 从输出中提取 OSC 7 目录上报并记录到会话。
+func trackSessionCWD(session *Session, data []byte) {
+	session.mu.Lock()
+	if cwd, ok := session.cwdParser.Feed(data); ok {
+		session.CWD = cwd
+		session.cwdReported = true
 	}
+	session.mu.Unlock()
 }